@@ -0,0 +1,94 @@
+// Package hass provides a minimal Home Assistant REST API client.
+package hass
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/httpf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/pkg/errors"
+)
+
+// State is an entity state from Home Assistant history.
+type State struct {
+	EntityID    string          `json:"entity_id"`
+	State       string          `json:"state"`
+	Attributes  json.RawMessage `json:"attributes"`
+	LastChanged time.Time       `json:"last_changed"`
+	LastUpdated time.Time       `json:"last_updated"`
+}
+
+type Client[C any] struct {
+	// URL is Home Assistant base URL, like http://homeassistant.local:8123.
+	URL string
+	// Token is a long-lived access token.
+	Token  string
+	client httpf.Client
+}
+
+func (c Client[C]) String() string {
+	return "hass.client"
+}
+
+func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	if c.URL == "" || c.Token == "" {
+		return errors.New("url and token are required")
+	}
+
+	c.client = &http.Client{
+		Transport: httpf.NewDefaultTransport(),
+	}
+
+	return nil
+}
+
+func (c *Client[C]) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	logf.Get(c).Resultf(req.Context(), logf.Debug, logf.Warn, "%s => %v", &httpf.RequestBuilder{Request: req}, err)
+	return resp, err
+}
+
+// GetState returns current entity state.
+func (c *Client[C]) GetState(ctx context.Context, entityID string) (*State, error) {
+	var state State
+	return &state, httpf.GET(strings.TrimRight(c.URL, "/")+"/api/states/"+entityID).
+		Auth(httpf.Bearer(c.Token)).
+		Exchange(ctx, c).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON(&state)).
+		Error()
+}
+
+// GetHistory returns all state changes of the entity in [since, until) period,
+// including changes of attributes only.
+func (c *Client[C]) GetHistory(ctx context.Context, entityID string, since, until time.Time) ([]State, error) {
+	var resp [][]State
+	if err := httpf.GET(strings.TrimRight(c.URL, "/")+"/api/history/period/"+since.UTC().Format(time.RFC3339)).
+		Auth(httpf.Bearer(c.Token)).
+		Query("end_time", until.UTC().Format(time.RFC3339)).
+		Query("filter_entity_id", entityID).
+		Query("significant_changes_only", "0").
+		Exchange(ctx, c).
+		CheckStatus(http.StatusOK).
+		DecodeBody(flu.JSON(&resp)).
+		Error(); err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, entityStates := range resp {
+		for _, state := range entityStates {
+			if !state.LastUpdated.Before(since) && state.LastUpdated.Before(until) {
+				states = append(states, state)
+			}
+		}
+	}
+
+	return states, nil
+}
@@ -21,6 +21,7 @@ var (
 	ErrRequestRateLimitExceeded = errors.New("request rate limit exceeded, please try again later")
 	ErrInsufficientPrivileges   = errors.New("insufficient privileges")
 	ErrNoDataFound              = errors.New("no data found")
+	ErrNoSession                = errors.New("no valid session")
 )
 
 type Credential struct {
@@ -34,12 +35,33 @@ func (cr Credential) String() string {
 	return "tinkoff.client." + cr.Username
 }
 
+// RateLimit allows at most Requests calls within Interval.
+type RateLimit struct {
+	Requests int          `yaml:"requests" doc:"Maximum number of requests within interval."`
+	Interval flu.Duration `yaml:"interval" doc:"Rate limit interval." format:"duration"`
+}
+
+// DefaultShoppingReceiptRateLimits are used when Client.ShoppingReceiptRateLimits are not set.
+var DefaultShoppingReceiptRateLimits = []RateLimit{
+	{Requests: 25, Interval: flu.Duration{Value: 75 * time.Second}},
+	{Requests: 75, Interval: flu.Duration{Value: 11 * time.Minute}},
+}
+
 type ConfirmFunc func(ctx context.Context, username string) (code string, err error)
 
+// SessionStorage persists session IDs between application restarts.
+type SessionStorage interface {
+	LoadSessionID(ctx context.Context, username string) (string, error)
+	StoreSessionID(ctx context.Context, username, sessionID string) error
+	RemoveSessionID(ctx context.Context, username string) error
+}
+
 type Client[C any] struct {
 	Credential
-	client *client
-	clock  syncf.Clock
+	SessionStorage            SessionStorage
+	ShoppingReceiptRateLimits []RateLimit
+	client                    *client
+	clock                     syncf.Clock
 }
 
 func (c Client[C]) String() string {
@@ -52,22 +74,40 @@ func (c *Client[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return err
 	}
 
+	rateLimits := c.ShoppingReceiptRateLimits
+	if len(rateLimits) == 0 {
+		rateLimits = DefaultShoppingReceiptRateLimits
+	}
+
+	shoppingReceiptMu := make(syncf.Lockers, len(rateLimits))
+	for i, rateLimit := range rateLimits {
+		shoppingReceiptMu[i] = syncf.Semaphore(app, rateLimit.Requests, rateLimit.Interval.Value)
+	}
+
 	c.clock = app
 	c.client = &client{
 		Credential: c.Credential,
 		confirm:    confirmFunc.Value,
+		sessions:   c.SessionStorage,
 		client: &http.Client{
 			Transport: httpf.NewDefaultTransport(),
 		},
 		commonsMu: map[string]syncf.Locker{
-			"shopping_receipt": syncf.Lockers{
-				syncf.Semaphore(app, 25, 75*time.Second),
-				syncf.Semaphore(app, 75, 11*time.Minute),
-			},
+			"shopping_receipt": shoppingReceiptMu,
 		},
 	}
 
-	if sessionID := c.Credential.SessionID; sessionID != "" {
+	sessionID := c.Credential.SessionID
+	if c.SessionStorage != nil {
+		storedSessionID, err := c.SessionStorage.LoadSessionID(ctx, c.Username)
+		if err != nil {
+			logf.Get(c).Warnf(ctx, "load session ID: %v", err)
+		} else if storedSessionID != "" {
+			sessionID = storedSessionID
+		}
+	}
+
+	if sessionID != "" {
 		c.client.sessionID = sessionID
 		c.client.ping()
 		if err := app.Manage(ctx, sessionLogger(func() {
@@ -169,7 +209,7 @@ func (c *Client[C]) GetShoppingReceipt(ctx context.Context, req OperationReceipt
 
 func (c *Client[C]) GetTradingOperations(ctx context.Context, req TradingOperations) ([]TradingOperation, error) {
 	if req.From.IsZero() {
-		req.From = tradingTimeStart
+		req.From = TradingTimeStart
 	}
 
 	if req.To.IsZero() {
@@ -196,17 +236,29 @@ func (c *Client[C]) GetPurchasedSecurities(ctx context.Context, req PurchasedSec
 
 	now := c.clock.Now()
 	for i := range resp.Data {
+		resp.Data[i].Username = c.Username
+		resp.Data[i].BrokerAccountType = req.BrokerAccountType
+		resp.Data[i].PortfolioCurrency = req.Currency
 		resp.Data[i].Time = now
 	}
 
 	return resp.Data, nil
 }
 
+func (c *Client[C]) GetBrokerAccounts(ctx context.Context, req BrokerAccounts) ([]BrokerAccount, error) {
+	resp, err := executeAuthorizedExchange[brokerAccountsResponse](ctx, c.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Accounts, nil
+}
+
 const maxCandleInterval = 12 * 30 * 24 * time.Hour // 1 year
 
 func (c *Client[C]) GetCandles(ctx context.Context, req Candles) ([]Candle, error) {
 	if req.From.IsZero() {
-		req.From = tradingTimeStart
+		req.From = TradingTimeStart
 	}
 
 	if req.To.IsZero() {
@@ -231,6 +283,7 @@ func (c *Client[C]) GetCandles(ctx context.Context, req Candles) ([]Candle, erro
 		}
 
 		for i := range partial.Candles {
+			partial.Candles[i].Username = c.Username
 			partial.Candles[i].Ticker = req.Ticker
 		}
 
@@ -245,6 +298,7 @@ type client struct {
 	Credential
 	client    httpf.Client
 	confirm   ConfirmFunc
+	sessions  SessionStorage
 	sessionID string
 	commonsMu map[string]syncf.Locker
 	mu        syncf.RWMutex
@@ -274,6 +328,12 @@ func (c *client) resetSessionID(ctx context.Context) error {
 
 	defer cancel()
 	c.sessionID = ""
+	if c.sessions != nil {
+		if err := c.sessions.RemoveSessionID(ctx, c.Username); err != nil {
+			logf.Get(c).Warnf(ctx, "remove session ID: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -392,6 +452,12 @@ func (c *client) authorize(ctx context.Context) error {
 		return errors.Wrap(err, "level up")
 	}
 
+	if c.sessions != nil {
+		if err := c.sessions.StoreSessionID(ctx, c.Username, c.sessionID); err != nil {
+			logf.Get(c).Warnf(ctx, "store session ID: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -415,7 +481,16 @@ func (c *client) ping() {
 	})
 }
 
-var retryContextKey = "tinkoff.retry"
+var (
+	retryContextKey           = "tinkoff.retry"
+	noAuthorizationContextKey = "tinkoff.no_authorization"
+)
+
+// WithoutAuthorization returns a context in which calls fail with ErrNoSession
+// instead of requesting user confirmation if there is no valid session.
+func WithoutAuthorization(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noAuthorizationContextKey, true)
+}
 
 func executeAuthorizedExchange[R any](ctx context.Context, client *client, exchange exchange[R]) (R, error) {
 	var zero R
@@ -460,6 +535,10 @@ func executeAuthorizedExchange[R any](ctx context.Context, client *client, excha
 		client.sessionID = ""
 	}
 
+	if noAuthorization, _ := ctx.Value(noAuthorizationContextKey).(bool); noAuthorization {
+		return zero, ErrNoSession
+	}
+
 	if err := client.authorize(ctx); err != nil {
 		_ = client.resetSessionID(ctx)
 		return zero, errors.Wrap(err, "authorize")
@@ -0,0 +1,290 @@
+package tinkoff
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"homebot/3rdparty/tinkoff/tinkofftest"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/pkg/errors"
+)
+
+type testConfig struct{}
+
+type testSessionStorage struct {
+	sessionIDs map[string]string
+	mu         sync.Mutex
+}
+
+func (s *testSessionStorage) LoadSessionID(_ context.Context, username string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessionIDs[username], nil
+}
+
+func (s *testSessionStorage) StoreSessionID(_ context.Context, username, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionIDs[username] = sessionID
+	return nil
+}
+
+func (s *testSessionStorage) RemoveSessionID(_ context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessionIDs, username)
+	return nil
+}
+
+type testEnv struct {
+	server   *tinkofftest.Server
+	confirms int
+	sessions SessionStorage
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	server := tinkofftest.NewServer()
+	host := Host
+	Host = server.URL
+	t.Cleanup(func() {
+		Host = host
+		server.Close()
+	})
+
+	return &testEnv{server: server}
+}
+
+func (e *testEnv) client(t *testing.T) *Client[testConfig] {
+	ctx := context.Background()
+	app := apfel.Boot[testConfig]{
+		Name:   "test",
+		Source: apfel.Environ(nil, "test"),
+	}.App(ctx)
+	t.Cleanup(func() { flu.CloseQuietly(app) })
+
+	if err := app.Use(ctx, &apfel.MixinAny[testConfig, ConfirmFunc]{
+		Value: func(ctx context.Context, username string) (string, error) {
+			e.confirms++
+			return e.server.Code, nil
+		},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client[testConfig]{
+		Credential: Credential{
+			Username: "test",
+			Phone:    e.server.Phone,
+			Password: e.server.Password,
+		},
+		SessionStorage: e.sessions,
+	}
+
+	if err := app.Use(ctx, client, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestClient_Authorize(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+		map[string]any{"id": "5002", "name": "Shared", "accountType": "SharedCurrent"},
+	}
+
+	client := env.client(t)
+	ctx := context.Background()
+	accounts, err := client.GetAccounts(ctx, Accounts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(accounts) != 1 || accounts[0].ID != "5001" || accounts[0].Username != "test" {
+		t.Errorf("unexpected accounts: %+v", accounts)
+	}
+
+	if _, err := client.GetAccounts(ctx, Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.confirms != 1 {
+		t.Errorf("expected 1 confirmation, got %d", env.confirms)
+	}
+
+	for _, operation := range []string{"session", "sign_up", "confirm", "level_up"} {
+		if calls := env.server.Calls(operation); calls == 0 {
+			t.Errorf("expected %s to be called", operation)
+		}
+	}
+}
+
+func TestClient_Reauthorize(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.TradingOperations = []any{
+		map[string]any{
+			"id":            1,
+			"date":          "2022-06-01T12:00:00+03:00",
+			"operationType": "Buy",
+			"ticker":        "TCSG",
+			"payment":       -1000.0,
+			"currency":      "RUB",
+			"description":   "Buy",
+		},
+	}
+
+	client := env.client(t)
+	ctx := context.Background()
+	if _, err := client.GetTradingOperations(ctx, TradingOperations{}); err != nil {
+		t.Fatal(err)
+	}
+
+	env.server.ExpireSessions()
+	items, err := client.GetTradingOperations(ctx, TradingOperations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].Username != "test" {
+		t.Errorf("unexpected trading operations: %+v", items)
+	}
+
+	if env.confirms != 2 {
+		t.Errorf("expected 2 confirmations, got %d", env.confirms)
+	}
+}
+
+func TestClient_WithoutAuthorization(t *testing.T) {
+	env := newTestEnv(t)
+	client := env.client(t)
+	ctx := context.Background()
+	if _, err := client.GetAccounts(WithoutAuthorization(ctx), Accounts{}); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("expected no session error, got %v", err)
+	}
+
+	if env.confirms != 0 {
+		t.Fatalf("expected no confirmations, got %d", env.confirms)
+	}
+
+	if _, err := client.GetAccounts(ctx, Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetAccounts(WithoutAuthorization(ctx), Accounts{}); err != nil {
+		t.Fatalf("expected valid session to be used, got %v", err)
+	}
+
+	env.server.ExpireSessions()
+	if _, err := client.GetAccounts(WithoutAuthorization(ctx), Accounts{}); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("expected no session error for expired session, got %v", err)
+	}
+
+	if env.confirms != 1 {
+		t.Errorf("expected 1 confirmation, got %d", env.confirms)
+	}
+}
+
+func TestClient_SessionStorage(t *testing.T) {
+	env := newTestEnv(t)
+	sessions := &testSessionStorage{sessionIDs: make(map[string]string)}
+	env.sessions = sessions
+
+	ctx := context.Background()
+	if _, err := env.client(t).GetAccounts(ctx, Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionID, _ := sessions.LoadSessionID(ctx, "test")
+	if level := env.server.AccessLevel(sessionID); level != tinkofftest.Client {
+		t.Fatalf("expected stored session to have %s access level, got [%s]", tinkofftest.Client, level)
+	}
+
+	if _, err := env.client(t).GetAccounts(ctx, Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.confirms != 1 {
+		t.Errorf("expected stored session to be reused, got %d confirmations", env.confirms)
+	}
+}
+
+func TestClient_ShoppingReceipt(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.Limits["shopping_receipt"] = 1
+	env.server.ShoppingReceipts[1] = map[string]any{
+		"receipt": map[string]any{
+			"totalSum": 30.0,
+			"items": []any{
+				map[string]any{"name": "Bread", "price": 10.0, "sum": 10.0, "quantity": 1.0},
+				map[string]any{"name": "Bread", "price": 10.0, "sum": 20.0, "quantity": 2.0},
+			},
+		},
+	}
+
+	client := env.client(t)
+	ctx := context.Background()
+	receipt, err := client.GetShoppingReceipt(ctx, OperationReceipt{OperationID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items := receipt.Receipt.Items; len(items) != 1 || items[0].Quantity != 3 || items[0].Sum != 30 {
+		t.Errorf("expected receipt items to be merged, got %+v", items)
+	}
+
+	if receipt.OperationID != 1 {
+		t.Errorf("expected operation ID 1, got %d", receipt.OperationID)
+	}
+
+	_, err = client.GetShoppingReceipt(ctx, OperationReceipt{OperationID: 1})
+	if !errors.Is(err, ErrRequestRateLimitExceeded) {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+}
+
+func TestClient_NoDataFound(t *testing.T) {
+	env := newTestEnv(t)
+	client := env.client(t)
+	_, err := client.GetShoppingReceipt(context.Background(), OperationReceipt{OperationID: 2})
+	if !errors.Is(err, ErrNoDataFound) {
+		t.Errorf("expected no data found error, got %v", err)
+	}
+}
+
+func TestGroupedRequests(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.ShoppingReceipts[1] = map[string]any{
+		"receipt": map[string]any{"totalSum": 10.0},
+	}
+
+	client := env.client(t)
+	ctx := context.Background()
+	if _, err := client.GetAccounts(ctx, Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := executeCommonExchange[[]commonResponse[ShoppingReceipt]](ctx, client.client,
+		groupedRequests[ShoppingReceipt]{
+			OperationReceipt{OperationID: 1},
+			OperationReceipt{OperationID: 2},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Payload) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(resp.Payload))
+	}
+
+	if err := resp.Payload[0].validate("OK"); err != nil || resp.Payload[0].Payload.Receipt.TotalSum != 10 {
+		t.Errorf("unexpected first response: %+v (%v)", resp.Payload[0], err)
+	}
+
+	if err := resp.Payload[1].validate("OK"); !errors.Is(err, ErrNoDataFound) {
+		t.Errorf("expected no data found error in second response, got %v", err)
+	}
+}
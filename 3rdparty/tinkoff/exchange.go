@@ -227,7 +227,7 @@ func (o Operations) EncodeValues(key string, v *url.Values) error {
 }
 
 type OperationReceipt struct {
-	OperationID uint64 `url:"operationId"`
+	OperationID uint64 `url:"operationId" json:"operationId"`
 }
 
 func (OperationReceipt) operation() string             { return "shopping_receipt" }
@@ -244,7 +244,7 @@ type TradingOperations struct {
 	OvernightsDisabled bool
 }
 
-var tradingTimeStart = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+var TradingTimeStart = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
 
 type TradingOperationsResponse struct {
 	Items []TradingOperation `json:"items"`
@@ -260,7 +260,7 @@ func formatTradingTime(value time.Time) string {
 func (tos TradingOperations) MarshalJSON() ([]byte, error) {
 	from := tos.From
 	if from.IsZero() {
-		from = tradingTimeStart
+		from = TradingTimeStart
 	}
 
 	to := tos.To
@@ -275,11 +275,15 @@ func (tos TradingOperations) MarshalJSON() ([]byte, error) {
 	})
 }
 
-var TinkoffRUB = PurchasedSecurities{
-	BrokerAccountType: "Tinkoff",
-	Currency:          "RUB",
+type BrokerAccounts struct{}
+
+type brokerAccountsResponse struct {
+	Accounts []BrokerAccount `json:"accounts"`
 }
 
+func (BrokerAccounts) path() string                         { return "/user/broker_accounts" }
+func (BrokerAccounts) response() (_ brokerAccountsResponse) { return }
+
 type PurchasedSecurities struct {
 	BrokerAccountType string `json:"brokerAccountType"`
 	Currency          string `json:"currency"`
@@ -131,10 +131,17 @@ type TradingOperation struct {
 	Description        string               `json:"description" gorm:"not null"`
 }
 
+type BrokerAccount struct {
+	Type string `json:"brokerAccountType"`
+}
+
 type PurchasedSecurity struct {
-	Ticker       string `json:"ticker" gorm:"primaryKey"`
-	SecurityType string `json:"securityType" gorm:"primaryKey"`
-	CurrentPrice struct {
+	Username          string `json:"-" gorm:"primaryKey;tenant"`
+	BrokerAccountType string `json:"-" gorm:"primaryKey"`
+	PortfolioCurrency string `json:"-" gorm:"primaryKey;type:char(3)"`
+	Ticker            string `json:"ticker" gorm:"primaryKey"`
+	SecurityType      string `json:"securityType" gorm:"primaryKey"`
+	CurrentPrice      struct {
 		Currency string  `json:"currency" gorm:"type:char(3);not null"`
 		Value    float64 `json:"value" gorm:"price;not null"`
 	} `json:"currentPrice" gorm:"embedded"`
@@ -155,13 +162,14 @@ func (d *CandleDate) UnmarshalJSON(data []byte) error {
 }
 
 type Candle struct {
-	Ticker string     `json:"-" gorm:"primaryKey"`
-	Date   CandleDate `json:"date" gorm:"primaryKey;type:date"`
-	Open   float64    `json:"o" gorm:"not null"`
-	Close  float64    `json:"c" gorm:"not null"`
-	High   float64    `json:"h" gorm:"not null"`
-	Low    float64    `json:"l" gorm:"not null"`
-	Volume float64    `json:"v" gorm:"not null"`
+	Username string     `json:"-" gorm:"primaryKey;tenant"`
+	Ticker   string     `json:"-" gorm:"primaryKey"`
+	Date     CandleDate `json:"date" gorm:"primaryKey;type:date"`
+	Open     float64    `json:"o" gorm:"not null"`
+	Close    float64    `json:"c" gorm:"not null"`
+	High     float64    `json:"h" gorm:"not null"`
+	Low      float64    `json:"l" gorm:"not null"`
+	Volume   float64    `json:"v" gorm:"not null"`
 }
 
 //
@@ -190,6 +198,16 @@ type ShoppingReceipt struct {
 	} `json:"receipt" gorm:"embedded"`
 }
 
+//
+// Session
+//
+
+type Session struct {
+	Username  string    `gorm:"primaryKey"`
+	ID        string    `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
 //
 // Account
 //
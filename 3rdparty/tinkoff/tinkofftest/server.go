@@ -0,0 +1,321 @@
+// Package tinkofftest provides a fake tinkoff.ru API server for use in tests.
+package tinkofftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	commonPrefix  = "/api/common/v1/"
+	tradingPrefix = "/api/trading"
+)
+
+// Access levels of a session.
+const (
+	Anonymous  = "ANONYMOUS"
+	Confirmed  = "CONFIRMED"
+	Registered = "REGISTERED"
+	Client     = "CLIENT"
+)
+
+// Server is a fake tinkoff.ru API server.
+// Fixtures are encoded into JSON as is, so they should follow the API format.
+type Server struct {
+	*httptest.Server
+
+	Phone    string
+	Password string
+	Code     string
+
+	Accounts          []any
+	Operations        map[string][]any
+	ShoppingReceipts  map[uint64]any
+	TradingOperations []any
+	BrokerAccounts    []any
+	Candles           map[string][]any
+
+	// PurchasedSecurities are keyed by broker account type and currency joined with a slash, like "Tinkoff/RUB".
+	PurchasedSecurities map[string][]any
+
+	// Limits contains maximum number of successful calls per operation (or trading path).
+	// REQUEST_RATE_LIMIT_EXCEEDED is returned for calls over the limit.
+	Limits map[string]int
+
+	sessions map[string]string
+	calls    map[string]int
+	lastID   int
+	mu       sync.Mutex
+}
+
+// NewServer starts a new Server.
+func NewServer() *Server {
+	s := &Server{
+		Phone:               "+70000000000",
+		Password:            "password",
+		Code:                "0000",
+		Operations:          make(map[string][]any),
+		ShoppingReceipts:    make(map[uint64]any),
+		PurchasedSecurities: make(map[string][]any),
+		Candles:             make(map[string][]any),
+		Limits:              make(map[string]int),
+		sessions:            make(map[string]string),
+		calls:               make(map[string]int),
+	}
+
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Calls returns the number of calls for the operation (or trading path).
+func (s *Server) Calls(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[operation]
+}
+
+// AccessLevel returns the access level of the session.
+func (s *Server) AccessLevel(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[sessionID]
+}
+
+// ExpireSessions invalidates all issued sessions.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]string)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resp any
+	switch {
+	case strings.HasPrefix(r.URL.Path, commonPrefix):
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		operation := strings.TrimPrefix(r.URL.Path, commonPrefix)
+		resp = s.common(operation, r.URL.Query().Get("sessionid"), r.PostForm)
+
+	case strings.HasPrefix(r.URL.Path, tradingPrefix):
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, tradingPrefix)
+		resp = s.trading(path, r.URL.Query().Get("sessionId"), body)
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type commonResponse struct {
+	ResultCode      string `json:"resultCode"`
+	ErrorMessage    string `json:"errorMessage,omitempty"`
+	Payload         any    `json:"payload,omitempty"`
+	OperationTicket string `json:"operationTicket,omitempty"`
+}
+
+func ok(payload any) commonResponse {
+	return commonResponse{ResultCode: "OK", Payload: payload}
+}
+
+func fail(resultCode string, message string) commonResponse {
+	return commonResponse{ResultCode: resultCode, ErrorMessage: message}
+}
+
+func (s *Server) limit(operation string) bool {
+	s.calls[operation]++
+	limit, ok := s.Limits[operation]
+	return ok && s.calls[operation] > limit
+}
+
+func (s *Server) common(operation string, sessionID string, values url.Values) commonResponse {
+	if s.limit(operation) {
+		return fail("REQUEST_RATE_LIMIT_EXCEEDED", "too many requests")
+	}
+
+	if operation == "session" {
+		s.lastID++
+		sessionID := fmt.Sprintf("session-%d", s.lastID)
+		s.sessions[sessionID] = Anonymous
+		return ok(sessionID)
+	}
+
+	level, exists := s.sessions[sessionID]
+	if !exists {
+		return fail("INSUFFICIENT_PRIVILEGES", "invalid session")
+	}
+
+	switch operation {
+	case "ping":
+		return ok(map[string]any{"accessLevel": level})
+
+	case "sign_up":
+		if phone := values.Get("phone"); phone != "" {
+			if phone != s.Phone {
+				return fail("INVALID_REQUEST_DATA", "unknown phone")
+			}
+
+			return commonResponse{
+				ResultCode:      "WAITING_CONFIRMATION",
+				OperationTicket: "ticket-" + sessionID,
+			}
+		}
+
+		if level != Confirmed {
+			return fail("INSUFFICIENT_PRIVILEGES", "confirmation required")
+		}
+
+		if values.Get("password") != s.Password {
+			return fail("INVALID_PASSWORD", "invalid password")
+		}
+
+		s.sessions[sessionID] = Registered
+		return ok(nil)
+
+	case "confirm":
+		var data struct {
+			SMSBYID string `json:"SMSBYID"`
+		}
+
+		if err := json.Unmarshal([]byte(values.Get("confirmationData")), &data); err != nil {
+			return fail("INVALID_REQUEST_DATA", err.Error())
+		}
+
+		if values.Get("initialOperationTicket") != "ticket-"+sessionID || data.SMSBYID != s.Code {
+			return fail("CONFIRMATION_FAILED", "invalid confirmation code")
+		}
+
+		s.sessions[sessionID] = Confirmed
+		return ok(nil)
+
+	case "level_up":
+		if level != Registered {
+			return fail("INSUFFICIENT_PRIVILEGES", "registration required")
+		}
+
+		s.sessions[sessionID] = Client
+		return ok(nil)
+
+	case "grouped_requests":
+		var requests []struct {
+			Key       int            `json:"key"`
+			Operation string         `json:"operation"`
+			Params    map[string]any `json:"params"`
+		}
+
+		if err := json.Unmarshal([]byte(values.Get("requestsData")), &requests); err != nil {
+			return fail("INVALID_REQUEST_DATA", err.Error())
+		}
+
+		payload := make([]commonResponse, len(requests))
+		for _, request := range requests {
+			if request.Key < 0 || request.Key >= len(requests) {
+				return fail("INVALID_REQUEST_DATA", "invalid key")
+			}
+
+			params := make(url.Values, len(request.Params))
+			for key, value := range request.Params {
+				params.Set(key, fmt.Sprint(value))
+			}
+
+			payload[request.Key] = s.common(request.Operation, sessionID, params)
+		}
+
+		return ok(payload)
+	}
+
+	if level != Client {
+		return fail("INSUFFICIENT_PRIVILEGES", "level up required")
+	}
+
+	switch operation {
+	case "accounts_flat":
+		return ok(s.Accounts)
+
+	case "operations":
+		return ok(s.Operations[values.Get("account")])
+
+	case "shopping_receipt":
+		operationID, err := strconv.ParseUint(values.Get("operationId"), 10, 64)
+		if err != nil {
+			return fail("INVALID_REQUEST_DATA", err.Error())
+		}
+
+		receipt, exists := s.ShoppingReceipts[operationID]
+		if !exists {
+			return fail("NO_DATA_FOUND", "receipt not found")
+		}
+
+		return ok(receipt)
+
+	default:
+		return fail("INTERNAL_ERROR", "unknown operation "+operation)
+	}
+}
+
+type tradingResponse struct {
+	Status  string `json:"status"`
+	Payload any    `json:"payload"`
+}
+
+func tradingError(code, message string) tradingResponse {
+	return tradingResponse{
+		Status: "Error",
+		Payload: map[string]any{
+			"code":    code,
+			"message": message,
+		},
+	}
+}
+
+func (s *Server) trading(path string, sessionID string, body map[string]any) tradingResponse {
+	if s.limit(path) {
+		return tradingError("RequestRateLimitExceeded", "too many requests")
+	}
+
+	if s.sessions[sessionID] != Client {
+		return tradingError("InsufficientPrivileges", "level up required")
+	}
+
+	var payload any
+	switch path {
+	case "/user/operations":
+		payload = map[string]any{"items": s.TradingOperations}
+	case "/user/broker_accounts":
+		payload = map[string]any{"accounts": s.BrokerAccounts}
+	case "/portfolio/purchased_securities":
+		key := fmt.Sprintf("%v/%v", body["brokerAccountType"], body["currency"])
+		payload = map[string]any{"data": s.PurchasedSecurities[key]}
+	case "/symbols/candles":
+		ticker, _ := body["ticker"].(string)
+		payload = map[string]any{"candles": s.Candles[ticker]}
+	default:
+		return tradingError("NotFound", "unknown path "+path)
+	}
+
+	return tradingResponse{
+		Status:  "Ok",
+		Payload: payload,
+	}
+}
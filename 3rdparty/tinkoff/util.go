@@ -1,6 +1,6 @@
 package tinkoff
 
-const Host = "https://www.tinkoff.ru"
+var Host = "https://www.tinkoff.ru"
 
 type operationSort []Operation
 
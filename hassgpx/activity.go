@@ -0,0 +1,41 @@
+package hassgpx
+
+import (
+	"math"
+)
+
+type Activity struct {
+	Name     string  `yaml:"name" doc:"Activity name. It is used as GPX track type and /get_gpx_track argument."`
+	MinSpeed float64 `yaml:"minSpeed,omitempty" doc:"Minimum average moving speed (km/h)."`
+	MaxSpeed float64 `yaml:"maxSpeed,omitempty" doc:"Maximum average moving speed (km/h). 0 means no limit."`
+}
+
+func (a Activity) distance(speed float64) float64 {
+	switch {
+	case speed < a.MinSpeed:
+		return a.MinSpeed - speed
+	case a.MaxSpeed > 0 && speed >= a.MaxSpeed:
+		return speed - a.MaxSpeed
+	default:
+		return 0
+	}
+}
+
+var defaultActivities = []Activity{
+	{Name: "walk", MaxSpeed: 7},
+	{Name: "run", MinSpeed: 7, MaxSpeed: 14},
+	{Name: "bicycle", MinSpeed: 14, MaxSpeed: 35},
+	{Name: "car", MinSpeed: 35},
+}
+
+// classify returns the name of the activity whose speed band is the closest to the speed.
+func classify(activities []Activity, speed float64) string {
+	name, best := "", math.Inf(1)
+	for _, activity := range activities {
+		if distance := activity.distance(speed); distance < best {
+			name, best = activity.Name, distance
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,29 @@
+package hassgpx
+
+import (
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	activities := []Activity{
+		{Name: "run", MinSpeed: 7, MaxSpeed: 14},
+		{Name: "bicycle", MinSpeed: 16, MaxSpeed: 35},
+	}
+
+	for speed, expected := range map[float64]string{
+		3:    "run",
+		10:   "run",
+		14:   "run",
+		15.5: "bicycle",
+		20:   "bicycle",
+		80:   "bicycle",
+	} {
+		if actual := classify(activities, speed); actual != expected {
+			t.Errorf("expected %.1f km/h to be classified as %s, got %s", speed, expected, actual)
+		}
+	}
+
+	if actual := classify(defaultActivities, 50); actual != "car" {
+		t.Errorf("expected 50 km/h to be classified as car, got %s", actual)
+	}
+}
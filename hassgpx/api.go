@@ -0,0 +1,108 @@
+package hassgpx
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"homebot/3rdparty/hass"
+
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/pkg/errors"
+)
+
+type APIConfig struct {
+	URL   string `yaml:"url" doc:"Home Assistant base URL." examples:"http://homeassistant.local:8123"`
+	Token string `yaml:"token" doc:"Long-lived access token. It may be created on Home Assistant user profile page."`
+}
+
+// trackerAttributes are device_tracker and person entity state attributes.
+type trackerAttributes struct {
+	SourceType  string   `json:"source_type"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	GPSAccuracy *float64 `json:"gps_accuracy"`
+	Altitude    *float64 `json:"altitude"`
+	Speed       *float64 `json:"speed"`
+	Course      *float64 `json:"course"`
+}
+
+type zoneAttributes struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Radius    *float64 `json:"radius"`
+}
+
+// APIStorage collects tracking data from Home Assistant REST API history.
+type APIStorage[C Context] struct {
+	client hass.Client[C]
+}
+
+func (s APIStorage[C]) String() string {
+	return "hassgpx.api"
+}
+
+func (s *APIStorage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
+	config := app.Config().HassGPXConfig().API
+	s.client = hass.Client[C]{URL: config.URL, Token: config.Token}
+	return app.Use(ctx, &s.client, false)
+}
+
+func (s *APIStorage[C]) GetPoints(ctx context.Context, entityID string, since, until time.Time, maxAccuracy float64) ([]Waypoint, error) {
+	if strings.Contains(entityID, "%") {
+		return nil, errors.Errorf("exact entity ID is required instead of [%s] pattern", entityID)
+	}
+
+	states, err := s.client.GetHistory(ctx, entityID, since, until)
+	if err != nil {
+		return nil, errors.Wrap(err, "get history")
+	}
+
+	points := make([]Waypoint, 0, len(states))
+	for _, state := range states {
+		var attrs trackerAttributes
+		if err := json.Unmarshal(state.Attributes, &attrs); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %s attributes", state.EntityID)
+		}
+
+		if attrs.SourceType != "" && attrs.SourceType != "gps" || attrs.Latitude == nil || attrs.Longitude == nil {
+			continue
+		}
+
+		if maxAccuracy > 0 && attrs.GPSAccuracy != nil && *attrs.GPSAccuracy > maxAccuracy {
+			continue
+		}
+
+		points = append(points, gpsRow{
+			Time:      recorderTime(state.LastUpdated),
+			Latitude:  *attrs.Latitude,
+			Longitude: *attrs.Longitude,
+			Altitude:  attrs.Altitude,
+			Speed:     attrs.Speed,
+			Course:    attrs.Course,
+		}.waypoint())
+	}
+
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points, nil
+}
+
+func (s *APIStorage[C]) GetZone(ctx context.Context, entityID string) (Zone, error) {
+	state, err := s.client.GetState(ctx, entityID)
+	if err != nil {
+		return Zone{}, errors.Wrap(err, "get state")
+	}
+
+	var attrs zoneAttributes
+	if err := json.Unmarshal(state.Attributes, &attrs); err != nil {
+		return Zone{}, errors.Wrapf(err, "unmarshal %s attributes", entityID)
+	}
+
+	if attrs.Latitude == nil || attrs.Longitude == nil || attrs.Radius == nil {
+		return Zone{}, errors.Errorf("%s is not a zone", entityID)
+	}
+
+	return Zone{Latitude: *attrs.Latitude, Longitude: *attrs.Longitude, Radius: *attrs.Radius}, nil
+}
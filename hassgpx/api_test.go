@@ -0,0 +1,107 @@
+package hassgpx
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"homebot/3rdparty/hass"
+)
+
+const testHistory = `[[
+	{"entity_id": "person.me", "state": "not_home", "last_updated": "2022-06-15T10:01:00.500000+00:00",
+		"attributes": {"latitude": 55.76, "longitude": 37.62, "gps_accuracy": 5, "source": "device_tracker.phone"}},
+	{"entity_id": "person.me", "state": "not_home", "last_updated": "2022-06-15T10:00:00+00:00",
+		"attributes": {"latitude": 55.75, "longitude": 37.61, "gps_accuracy": 10, "altitude": 150.5, "speed": 4}},
+	{"entity_id": "person.me", "state": "not_home", "last_updated": "2022-06-15T10:02:00+00:00",
+		"attributes": {"latitude": 55.77, "longitude": 37.63, "gps_accuracy": 100}},
+	{"entity_id": "person.me", "state": "home", "last_updated": "2022-06-15T10:03:00+00:00",
+		"attributes": {"source_type": "router"}},
+	{"entity_id": "person.me", "state": "home", "last_updated": "2022-06-15T10:04:00+00:00",
+		"attributes": {"source_type": "gps", "latitude": 55.78, "longitude": 37.64}},
+	{"entity_id": "person.me", "state": "home", "last_updated": "2022-06-15T10:05:00+00:00",
+		"attributes": {"source_type": "gps", "latitude": 55.79, "longitude": 37.65, "gps_accuracy": 5}}
+]]`
+
+func TestAPIStorage(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/api/states/zone.home" {
+			_, _ = w.Write([]byte(`{"entity_id": "zone.home", "state": "0",
+				"attributes": {"latitude": 55.75, "longitude": 37.61, "radius": 150, "friendly_name": "Home"}}`))
+			return
+		}
+
+		query := r.URL.Query()
+		if r.URL.Path != "/api/history/period/2022-06-15T10:00:00Z" ||
+			query.Get("end_time") != "2022-06-15T10:05:00Z" ||
+			query.Get("filter_entity_id") != "person.me" ||
+			query.Get("significant_changes_only") != "0" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		_, _ = w.Write([]byte(testHistory))
+	}))
+
+	defer server.Close()
+
+	storage := &APIStorage[Context]{client: hass.Client[Context]{URL: server.URL + "/", Token: "token"}}
+	if err := storage.client.Include(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2022, 6, 15, 10, 0, 0, 0, time.UTC)
+	points, err := storage.GetPoints(ctx, "person.me", start, start.Add(5*time.Minute), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 3 {
+		t.Fatalf("expected 3 points, got %d", len(points))
+	}
+
+	first := points[0]
+	if !first.Time.Equal(start) || first.Latitude != 55.75 || first.Longitude != 37.61 ||
+		first.Elevation == nil || *first.Elevation != 150.5 ||
+		first.Extensions == nil || *first.Extensions.TrackPoint.Speed != 4 {
+		t.Errorf("unexpected first point: %+v", first)
+	}
+
+	if !points[1].Time.Equal(start.Add(time.Minute+500*time.Millisecond)) || points[1].Latitude != 55.76 {
+		t.Errorf("unexpected second point: %+v", points[1])
+	}
+
+	if points[2].Latitude != 55.78 {
+		t.Errorf("unexpected third point: %+v", points[2])
+	}
+
+	zone, err := storage.GetZone(ctx, "zone.home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if zone != (Zone{Latitude: 55.75, Longitude: 37.61, Radius: 150}) {
+		t.Errorf("unexpected zone: %+v", zone)
+	}
+
+	if _, err := storage.GetZone(ctx, "zone.work"); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+
+	if _, err := storage.GetPoints(ctx, "device_tracker.%", start, start.Add(5*time.Minute), 50); err == nil {
+		t.Error("expected error for entity ID pattern")
+	}
+
+	storage.client.Token = "invalid"
+	if _, err := storage.GetPoints(ctx, "person.me", start, start.Add(5*time.Minute), 50); err == nil {
+		t.Error("expected error for invalid token")
+	}
+}
@@ -0,0 +1,24 @@
+create or replace view gps
+            (state_id, old_state_id, time, entity_id, latitude, longitude, gps_accuracy, altitude, course, speed,
+             vertical_accuracy)
+as
+select states.state_id,
+       states.old_state_id,
+       {{.Time}}                                                                                      AS `time`,
+       {{.EntityID}}                                                                                  AS entity_id,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.latitude')) as decimal(18, 8))          AS latitude,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.longitude')) as decimal(18, 8))         AS longitude,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.gps_accuracy')) as decimal(18, 8))      AS gps_accuracy,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.altitude')) as decimal(18, 8))          AS altitude,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.course')) as decimal(18, 8))            AS course,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.speed')) as decimal(18, 8))             AS speed,
+       cast(json_unquote(json_extract({{.Attributes}}, '$.vertical_accuracy')) as decimal(18, 8)) AS vertical_accuracy
+from states
+{{- if .StateAttributes}}
+         left join state_attributes on state_attributes.attributes_id = states.attributes_id
+{{- end}}
+{{- if .StatesMeta}}
+         left join states_meta on states_meta.metadata_id = states.metadata_id
+{{- end}}
+where json_unquote(json_extract({{.Attributes}}, '$.source_type')) = 'gps'
+order by states.state_id
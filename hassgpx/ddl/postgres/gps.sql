@@ -0,0 +1,27 @@
+drop view if exists gps;
+
+create view gps
+            (state_id, old_state_id, time, entity_id, latitude, longitude, gps_accuracy, altitude, course, speed,
+             vertical_accuracy)
+as
+select states.state_id,
+       states.old_state_id,
+       {{.Time}}                                                  AS "time",
+       {{.EntityID}}                                              AS entity_id,
+       jsonb_extract_path(a.attrs, 'latitude')::numeric          AS latitude,
+       jsonb_extract_path(a.attrs, 'longitude')::numeric         AS longitude,
+       jsonb_extract_path(a.attrs, 'gps_accuracy')::numeric      AS gps_accuracy,
+       jsonb_extract_path(a.attrs, 'altitude')::numeric          AS altitude,
+       jsonb_extract_path(a.attrs, 'course')::numeric            AS course,
+       jsonb_extract_path(a.attrs, 'speed')::numeric             AS speed,
+       jsonb_extract_path(a.attrs, 'vertical_accuracy')::numeric AS vertical_accuracy
+from states
+{{- if .StateAttributes}}
+         left join state_attributes on state_attributes.attributes_id = states.attributes_id
+{{- end}}
+{{- if .StatesMeta}}
+         left join states_meta on states_meta.metadata_id = states.metadata_id
+{{- end}}
+         cross join lateral (select {{.Attributes}}::jsonb AS attrs) a
+where jsonb_extract_path_text(a.attrs, 'source_type') = 'gps'
+order by states.state_id
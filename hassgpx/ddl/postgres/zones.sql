@@ -0,0 +1,20 @@
+drop view if exists zones;
+
+create view zones (entity_id, latitude, longitude, radius)
+as
+select entity_id, latitude, longitude, radius
+from (select {{.EntityID}}                                    AS entity_id,
+             jsonb_extract_path(a.attrs, 'latitude')::numeric  AS latitude,
+             jsonb_extract_path(a.attrs, 'longitude')::numeric AS longitude,
+             jsonb_extract_path(a.attrs, 'radius')::numeric    AS radius,
+             row_number() over (partition by {{.EntityID}} order by states.state_id desc) AS n
+      from states
+{{- if .StateAttributes}}
+               left join state_attributes on state_attributes.attributes_id = states.attributes_id
+{{- end}}
+{{- if .StatesMeta}}
+               left join states_meta on states_meta.metadata_id = states.metadata_id
+{{- end}}
+               cross join lateral (select {{.Attributes}}::jsonb AS attrs) a
+      where {{.EntityID}} like 'zone.%') z
+where n = 1
@@ -0,0 +1,26 @@
+drop view if exists gps;
+
+create view gps
+            (state_id, old_state_id, time, entity_id, latitude, longitude, gps_accuracy, altitude, course, speed,
+             vertical_accuracy)
+as
+select states.state_id,
+       states.old_state_id,
+       {{.Time}}                                                     AS "time",
+       {{.EntityID}}                                                 AS entity_id,
+       json_extract({{.Attributes}}, '$.latitude')          AS latitude,
+       json_extract({{.Attributes}}, '$.longitude')         AS longitude,
+       json_extract({{.Attributes}}, '$.gps_accuracy')      AS gps_accuracy,
+       json_extract({{.Attributes}}, '$.altitude')          AS altitude,
+       json_extract({{.Attributes}}, '$.course')            AS course,
+       json_extract({{.Attributes}}, '$.speed')             AS speed,
+       json_extract({{.Attributes}}, '$.vertical_accuracy') AS vertical_accuracy
+from states
+{{- if .StateAttributes}}
+         left join state_attributes on state_attributes.attributes_id = states.attributes_id
+{{- end}}
+{{- if .StatesMeta}}
+         left join states_meta on states_meta.metadata_id = states.metadata_id
+{{- end}}
+where json_extract({{.Attributes}}, '$.source_type') = 'gps'
+order by states.state_id
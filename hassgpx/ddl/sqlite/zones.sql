@@ -0,0 +1,19 @@
+drop view if exists zones;
+
+create view zones (entity_id, latitude, longitude, radius)
+as
+select entity_id, latitude, longitude, radius
+from (select {{.EntityID}}                            AS entity_id,
+             json_extract({{.Attributes}}, '$.latitude')  AS latitude,
+             json_extract({{.Attributes}}, '$.longitude') AS longitude,
+             json_extract({{.Attributes}}, '$.radius')    AS radius,
+             row_number() over (partition by {{.EntityID}} order by states.state_id desc) AS n
+      from states
+{{- if .StateAttributes}}
+               left join state_attributes on state_attributes.attributes_id = states.attributes_id
+{{- end}}
+{{- if .StatesMeta}}
+               left join states_meta on states_meta.metadata_id = states.metadata_id
+{{- end}}
+      where {{.EntityID}} like 'zone.%') z
+where n = 1
@@ -0,0 +1,123 @@
+package hassgpx
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+type Delivery struct {
+	Interval    flu.Duration `yaml:"interval,omitempty" doc:"Interval between checks for finished rides. Automatic delivery is disabled if not set." format:"duration"`
+	IdleTime    flu.Duration `yaml:"idleTime,omitempty" doc:"A ride is considered finished when there was no movement for this interval after its last tracking point.\nIt must not be less than rideGap." default:"10m" format:"duration"`
+	MinDistance float64      `yaml:"minDistance,omitempty" doc:"Finished rides shorter than this distance (in kilometers) are not delivered." default:"0.5"`
+}
+
+// deliveryKey identifies the delivery of entity rides to a user.
+type deliveryKey struct {
+	entityID string
+	userID   telegram.ID
+}
+
+type scheduler func()
+
+func (s scheduler) Close() error {
+	s()
+	return nil
+}
+
+// finishedRides returns rides started after the watermark which are finished by now
+// and are long enough to be delivered, and the new watermark.
+func finishedRides(rides []Ride, watermark, now time.Time, idleTime time.Duration, minDistance float64) ([]Ride, time.Time) {
+	var finished []Ride
+	for _, ride := range rides {
+		if !ride.Start().After(watermark) {
+			continue
+		}
+
+		if now.Sub(ride.End()) < idleTime {
+			break
+		}
+
+		watermark = ride.End()
+		if rideStats(ride).Distance >= minDistance {
+			finished = append(finished, ride)
+		}
+	}
+
+	return finished, watermark
+}
+
+func (m *Mixin[C]) deliver(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for entityID, userIDs := range m.entities {
+			m.deliverFinishedRides(ctx, m.telegram.Bot(), entityID, userIDs)
+		}
+	}
+}
+
+func (m *Mixin[C]) deliverFinishedRides(ctx context.Context, client telegram.Client, entityID string, userIDs []telegram.ID) {
+	now := m.clock.Now()
+	since := now
+	for _, userID := range userIDs {
+		if watermark := m.delivered[deliveryKey{entityID, userID}]; watermark.Before(since) {
+			since = watermark
+		}
+	}
+
+	// rides started before the watermark were either delivered or were in progress on startup,
+	// so points are requested a bit earlier in order to detect their start properly
+	rides, err := m.findRides(ctx, entityID, since.Add(-m.rideGap), now, "")
+	if err != nil {
+		logf.Get(m).Warnf(ctx, "find rides for [%s]: %v", entityID, err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		key := deliveryKey{entityID, userID}
+		m.delivered[key] = m.deliverRides(ctx, client, key, rides, now)
+	}
+}
+
+// deliverRides sends finished rides which were not delivered to the user yet and returns the new watermark.
+func (m *Mixin[C]) deliverRides(ctx context.Context, client telegram.Client, key deliveryKey, rides []Ride, now time.Time) time.Time {
+	watermark := m.delivered[key]
+	finished, next := finishedRides(rides, watermark, now, m.delivery.IdleTime.Value, m.delivery.MinDistance)
+	for _, ride := range finished {
+		if err := m.sendRides(ctx, client, key.userID, []Ride{ride}, m.format); err != nil {
+			if !isPermanentDeliveryError(err) {
+				// the ride will be delivered again on the next check
+				logf.Get(m).Errorf(ctx, "deliver ride for [%s] to %s: %v", key.entityID, key.userID, err)
+				return watermark
+			}
+
+			logf.Get(m).Errorf(ctx, "skip ride for [%s] to %s: %v", key.entityID, key.userID, err)
+		}
+
+		watermark = ride.End()
+	}
+
+	return next
+}
+
+// isPermanentDeliveryError checks if the ride will never be delivered, so there is no point in retrying.
+func isPermanentDeliveryError(err error) bool {
+	if errors.Is(err, errRideHidden) {
+		return true
+	}
+
+	var apiErr telegram.Error
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == http.StatusBadRequest
+}
@@ -0,0 +1,203 @@
+package hassgpx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+type testStorage []Waypoint
+
+func (s testStorage) GetPoints(_ context.Context, _ string, since, until time.Time, _ float64) ([]Waypoint, error) {
+	var points []Waypoint
+	for _, point := range s {
+		if !point.Time.Before(since) && point.Time.Before(until) {
+			points = append(points, point)
+		}
+	}
+
+	return points, nil
+}
+
+func (s testStorage) GetZone(_ context.Context, entityID string) (Zone, error) {
+	return Zone{}, errors.Errorf("unknown zone [%s]", entityID)
+}
+
+// testClient records sent items. Other telegram.Client methods are not implemented.
+type testClient struct {
+	telegram.Client
+	sent []telegram.Sendable
+	err  error
+}
+
+func (c *testClient) Send(_ context.Context, _ telegram.ChatID, item telegram.Sendable, _ *telegram.SendOptions) (*telegram.Message, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.sent = append(c.sent, item)
+	return new(telegram.Message), nil
+}
+
+func newTestMixin(points []Waypoint, now time.Time) *Mixin[Context] {
+	return &Mixin[Context]{
+		clock:        syncf.ClockFunc(func() time.Time { return now }),
+		storage:      testStorage(points),
+		users:        map[telegram.ID]string{1: "person.me"},
+		maxSpeed:     55,
+		moveInterval: time.Minute,
+		rideGap:      10 * time.Minute,
+		speedWindow:  time.Minute,
+		activities:   defaultActivities,
+		location:     time.UTC,
+		format:       "gpx",
+		delivery: Delivery{
+			IdleTime:    flu.Duration{Value: 10 * time.Minute},
+			MinDistance: 0.5,
+		},
+		delivered: make(map[deliveryKey]time.Time),
+	}
+}
+
+func TestFinishedRides(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.61).
+		north(18, 60).
+		pause(20*time.Minute).
+		north(18, 5).
+		pause(20*time.Minute).
+		east(18, 60).
+		points
+
+	rides := splitRides(points, 10*time.Minute)
+	if len(rides) != 3 {
+		t.Fatalf("expected 3 rides, got %d", len(rides))
+	}
+
+	idleTime, minDistance := 10*time.Minute, 0.5
+	start := rides[0].Start()
+	finished, watermark := finishedRides(rides, start.Add(-time.Hour), rides[2].End().Add(5*time.Minute), idleTime, minDistance)
+	if len(finished) != 1 || !finished[0].Start().Equal(start) {
+		t.Errorf("expected first ride only, got %d rides", len(finished))
+	}
+
+	if !watermark.Equal(rides[1].End()) {
+		t.Errorf("expected watermark at short ride end, got %s", watermark)
+	}
+
+	finished, watermark = finishedRides(rides, watermark, rides[2].End().Add(idleTime), idleTime, minDistance)
+	if len(finished) != 1 || !finished[0].Start().Equal(rides[2].Start()) {
+		t.Errorf("expected last ride only, got %d rides", len(finished))
+	}
+
+	if !watermark.Equal(rides[2].End()) {
+		t.Errorf("expected watermark at last ride end, got %s", watermark)
+	}
+
+	if finished, _ := finishedRides(rides, watermark, rides[2].End().Add(time.Hour), idleTime, minDistance); len(finished) != 0 {
+		t.Errorf("expected no rides to be delivered twice, got %d rides", len(finished))
+	}
+
+	finished, _ = finishedRides(rides, start.Add(time.Minute), rides[2].End().Add(time.Hour), idleTime, minDistance)
+	if len(finished) != 1 || !finished[0].Start().Equal(rides[2].Start()) {
+		t.Errorf("expected ride in progress on startup to be skipped, got %d rides", len(finished))
+	}
+}
+
+func TestDeliverFinishedRides_SendError(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.61).
+		north(18, 60).
+		pause(20*time.Minute).
+		east(18, 60).
+		points
+
+	ctx := context.Background()
+	start, end := points[0].Time, points[len(points)-1].Time
+	m := newTestMixin(points, end.Add(15*time.Minute))
+	key := deliveryKey{"person.me", 1}
+	m.delivered[key] = start.Add(-time.Hour)
+
+	client := &testClient{err: errors.New("telegram is down")}
+	m.deliverFinishedRides(ctx, client, "person.me", []telegram.ID{1})
+	if watermark := m.delivered[key]; !watermark.Equal(start.Add(-time.Hour)) {
+		t.Errorf("expected watermark not to advance on send error, got %s", watermark)
+	}
+
+	client.err = nil
+	m.deliverFinishedRides(ctx, client, "person.me", []telegram.ID{1})
+	// each ride is sent as a document followed by a preview
+	if len(client.sent) != 4 {
+		t.Errorf("expected both rides to be delivered after recovery, got %d sent items", len(client.sent))
+	}
+
+	if watermark := m.delivered[key]; !watermark.Equal(end) {
+		t.Errorf("expected watermark at last ride end, got %s", watermark)
+	}
+}
+
+func TestDeliverFinishedRides_PermanentError(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.61).
+		north(18, 60).
+		pause(20*time.Minute).
+		east(18, 60).
+		points
+
+	ctx := context.Background()
+	start, end := points[0].Time, points[len(points)-1].Time
+	m := newTestMixin(points, end.Add(15*time.Minute))
+	key := deliveryKey{"person.me", 1}
+	m.delivered[key] = start.Add(-time.Hour)
+
+	client := &testClient{err: telegram.Error{ErrorCode: 400, Description: "Bad Request: file is too big"}}
+	m.deliverFinishedRides(ctx, client, "person.me", []telegram.ID{1})
+	if watermark := m.delivered[key]; !watermark.Equal(end) {
+		t.Errorf("expected rides to be skipped on bad request, got watermark %s", watermark)
+	}
+
+	// all points are inside the privacy zone
+	m.privacyZones = []PrivacyZone{{Zone: Zone{Latitude: 55.75, Longitude: 37.61, Radius: 100000}}}
+	m.delivered[key] = start.Add(-time.Hour)
+	client.err = nil
+	m.deliverFinishedRides(ctx, client, "person.me", []telegram.ID{1})
+	if len(client.sent) != 0 {
+		t.Errorf("expected hidden rides not to be sent, got %d sent items", len(client.sent))
+	}
+
+	if watermark := m.delivered[key]; !watermark.Equal(end) {
+		t.Errorf("expected hidden rides to be skipped, got watermark %s", watermark)
+	}
+}
+
+func TestDeliverFinishedRides_SeveralUsers(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.61).
+		north(18, 60).
+		pause(20*time.Minute).
+		east(18, 60).
+		points
+
+	ctx := context.Background()
+	start, end := points[0].Time, points[len(points)-1].Time
+	m := newTestMixin(points, end.Add(15*time.Minute))
+	rides := splitRides(points, m.rideGap)
+	// the first user has already received the first ride
+	first, second := deliveryKey{"person.me", 1}, deliveryKey{"person.me", 2}
+	m.delivered[first] = rides[0].End()
+	m.delivered[second] = start.Add(-time.Hour)
+
+	client := new(testClient)
+	m.deliverFinishedRides(ctx, client, "person.me", []telegram.ID{1, 2})
+	// each ride is sent as a document followed by a preview
+	if len(client.sent) != 6 {
+		t.Errorf("expected one ride for the first user and two rides for the second one, got %d sent items", len(client.sent))
+	}
+
+	for _, key := range []deliveryKey{first, second} {
+		if watermark := m.delivered[key]; !watermark.Equal(end) {
+			t.Errorf("expected watermark at last ride end for %s, got %s", key.userID, watermark)
+		}
+	}
+}
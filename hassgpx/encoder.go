@@ -0,0 +1,230 @@
+package hassgpx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoder writes tracks in a specific file format.
+type Encoder interface {
+	Extension() string
+	Encode(w io.Writer, tracks []Track) error
+}
+
+var encoders = map[string]Encoder{
+	"gpx":     gpxEncoder{},
+	"kml":     kmlEncoder{},
+	"geojson": geoJSONEncoder{},
+	"tcx":     tcxEncoder{},
+	"fit":     fitEncoder{},
+}
+
+func encodeXML(w io.Writer, value any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(value)
+}
+
+type gpxEncoder struct{}
+
+func (gpxEncoder) Extension() string {
+	return "gpx"
+}
+
+func (gpxEncoder) Encode(w io.Writer, tracks []Track) error {
+	first, last := tracks[0].Segment.Waypoints, tracks[len(tracks)-1].Segment.Waypoints
+	return encodeXML(w, &GPX{
+		XMLNS:   "https://www.topografix.com/GPX/1/1",
+		Creator: "github.com/jfk9w-go/homebot",
+		Version: "1.1",
+		XSI:     "https://www.w3.org/2001/XMLSchema-instance",
+		TPX:     "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		SchemaLocation: "https://www.topografix.com/GPX/1/1 https://www.topografix.com/GPX/1/1/gpx.xsd " +
+			"http://www.garmin.com/xmlschemas/TrackPointExtension/v2 https://www8.garmin.com/xmlschemas/TrackPointExtensionv2.xsd",
+		Metadata: Metadata{
+			Name: first[0].Time.String(),
+			Desc: fmt.Sprintf("%s – %s", first[0].Time, last[len(last)-1].Time),
+		},
+		Tracks: tracks,
+	})
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description,omitempty"`
+	LineString  struct {
+		Tessellate  int    `xml:"tessellate"`
+		Coordinates string `xml:"coordinates"`
+	} `xml:"LineString"`
+}
+
+type kml struct {
+	XMLName  xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document struct {
+		Name       string         `xml:"name"`
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlEncoder struct{}
+
+func (kmlEncoder) Extension() string {
+	return "kml"
+}
+
+func (kmlEncoder) Encode(w io.Writer, tracks []Track) error {
+	var doc kml
+	doc.Document.Name = tracks[0].Name
+	doc.Document.Placemarks = make([]kmlPlacemark, len(tracks))
+	for i, track := range tracks {
+		placemark := &doc.Document.Placemarks[i]
+		placemark.Name = track.Name
+		placemark.Description = strings.TrimSpace(track.Type + " " + track.Desc)
+		placemark.LineString.Tessellate = 1
+		coordinates := make([]string, len(track.Segment.Waypoints))
+		for j, waypoint := range track.Segment.Waypoints {
+			coordinates[j] = formatFloat(waypoint.Longitude) + "," + formatFloat(waypoint.Latitude)
+			if waypoint.Elevation != nil {
+				coordinates[j] += "," + formatFloat(*waypoint.Elevation)
+			}
+		}
+
+		placemark.LineString.Coordinates = strings.Join(coordinates, " ")
+	}
+
+	return encodeXML(w, &doc)
+}
+
+type geoJSONEncoder struct{}
+
+func (geoJSONEncoder) Extension() string {
+	return "geojson"
+}
+
+func (geoJSONEncoder) Encode(w io.Writer, tracks []Track) error {
+	features := make([]any, len(tracks))
+	for i, track := range tracks {
+		coordinates := make([][]float64, len(track.Segment.Waypoints))
+		times := make([]time.Time, len(track.Segment.Waypoints))
+		for j, waypoint := range track.Segment.Waypoints {
+			coordinates[j] = []float64{waypoint.Longitude, waypoint.Latitude}
+			if waypoint.Elevation != nil {
+				coordinates[j] = append(coordinates[j], *waypoint.Elevation)
+			}
+
+			times[j] = waypoint.Time
+		}
+
+		features[i] = map[string]any{
+			"type": "Feature",
+			"geometry": map[string]any{
+				"type":        "LineString",
+				"coordinates": coordinates,
+			},
+			"properties": map[string]any{
+				"name":       track.Name,
+				"activity":   track.Type,
+				"coordTimes": times,
+			},
+		}
+	}
+
+	return json.NewEncoder(w).Encode(map[string]any{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+type tcxTrackpoint struct {
+	Time     time.Time `xml:"Time"`
+	Position struct {
+		Latitude  float64 `xml:"LatitudeDegrees"`
+		Longitude float64 `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+	Altitude *float64 `xml:"AltitudeMeters,omitempty"`
+	Distance float64  `xml:"DistanceMeters"`
+}
+
+type tcxLap struct {
+	StartTime     time.Time       `xml:"StartTime,attr"`
+	TotalTime     float64         `xml:"TotalTimeSeconds"`
+	Distance      float64         `xml:"DistanceMeters"`
+	Calories      int             `xml:"Calories"`
+	Intensity     string          `xml:"Intensity"`
+	TriggerMethod string          `xml:"TriggerMethod"`
+	Trackpoints   []tcxTrackpoint `xml:"Track>Trackpoint"`
+}
+
+type tcxActivity struct {
+	Sport string    `xml:"Sport,attr"`
+	ID    time.Time `xml:"Id"`
+	Lap   tcxLap    `xml:"Lap"`
+}
+
+type tcx struct {
+	XMLName    xml.Name      `xml:"http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2 TrainingCenterDatabase"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+func tcxSport(activity string) string {
+	switch activity {
+	case "run":
+		return "Running"
+	case "bicycle":
+		return "Biking"
+	default:
+		return "Other"
+	}
+}
+
+type tcxEncoder struct{}
+
+func (tcxEncoder) Extension() string {
+	return "tcx"
+}
+
+func (tcxEncoder) Encode(w io.Writer, tracks []Track) error {
+	doc := tcx{Activities: make([]tcxActivity, len(tracks))}
+	for i, track := range tracks {
+		waypoints := track.Segment.Waypoints
+		start, end := waypoints[0].Time, waypoints[len(waypoints)-1].Time
+		activity := &doc.Activities[i]
+		activity.Sport = tcxSport(track.Type)
+		activity.ID = start
+		activity.Lap = tcxLap{
+			StartTime:     start,
+			TotalTime:     end.Sub(start).Seconds(),
+			Intensity:     "Active",
+			TriggerMethod: "Manual",
+			Trackpoints:   make([]tcxTrackpoint, len(waypoints)),
+		}
+
+		for j, waypoint := range waypoints {
+			if j > 0 {
+				activity.Lap.Distance += haversine(waypoints[j-1], waypoint)
+			}
+
+			trackpoint := &activity.Lap.Trackpoints[j]
+			trackpoint.Time = waypoint.Time
+			trackpoint.Position.Latitude = waypoint.Latitude
+			trackpoint.Position.Longitude = waypoint.Longitude
+			trackpoint.Altitude = waypoint.Elevation
+			trackpoint.Distance = activity.Lap.Distance
+		}
+	}
+
+	return encodeXML(w, &doc)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,122 @@
+package hassgpx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func testTracks() []Track {
+	start := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	tracks := make([]Track, 2)
+	for i := range tracks {
+		waypoints := newTrackBuilder(55.75, 37.6).north(20, 5).points
+		for j := range waypoints {
+			waypoints[j].Time = start.Add(time.Duration(i)*time.Hour + time.Duration(j)*10*time.Second)
+			waypoints[j].Elevation = float(150)
+			waypoints[j].Extensions = &WaypointExtensions{TrackPoint: TrackPointExtension{Speed: float(5.5)}}
+		}
+
+		tracks[i] = Track{
+			Metadata: Metadata{Name: waypoints[0].Time.String()},
+			Type:     "bicycle",
+			Segment:  TrackSegment{Waypoints: waypoints},
+		}
+	}
+
+	return tracks
+}
+
+func TestEncoders_XML(t *testing.T) {
+	for format, target := range map[string]any{
+		"gpx": new(GPX),
+		"kml": new(kml),
+		"tcx": new(tcx),
+	} {
+		var buffer bytes.Buffer
+		if err := encoders[format].Encode(&buffer, testTracks()); err != nil {
+			t.Fatalf("encode %s: %v", format, err)
+		}
+
+		if err := xml.Unmarshal(buffer.Bytes(), target); err != nil {
+			t.Errorf("decode %s: %v", format, err)
+		}
+	}
+}
+
+func TestEncoders_GeoJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := encoders["geojson"].Encode(&buffer, testTracks()); err != nil {
+		t.Fatal(err)
+	}
+
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Coordinates [][]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+
+	if err := json.Unmarshal(buffer.Bytes(), &collection); err != nil {
+		t.Fatal(err)
+	}
+
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 2 ||
+		len(collection.Features[0].Geometry.Coordinates) != 6 || collection.Features[0].Geometry.Coordinates[0][2] != 150 {
+		t.Errorf("unexpected feature collection: %s", buffer.String())
+	}
+}
+
+func TestEncoders_FIT(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := encoders["fit"].Encode(&buffer, testTracks()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buffer.Bytes()
+	if string(data[8:12]) != ".FIT" || int(binary.LittleEndian.Uint32(data[4:8])) != len(data)-16 {
+		t.Fatalf("invalid header: %v", data[:14])
+	}
+
+	if crc := fitCRC(0, data[:14]); crc != 0 {
+		t.Errorf("invalid header crc")
+	}
+
+	if crc := fitCRC(0, data); crc != 0 {
+		t.Errorf("invalid file crc")
+	}
+
+	sizes := make(map[byte]int)
+	globals := make(map[byte]uint16)
+	messages := make(map[uint16]int)
+	for i := 14; i < len(data)-2; {
+		header := data[i]
+		local := header & 0x0F
+		i++
+		if header&0x40 != 0 {
+			globals[local] = binary.LittleEndian.Uint16(data[i+2:])
+			fields := int(data[i+4])
+			sizes[local] = 0
+			for j := 0; j < fields; j++ {
+				sizes[local] += int(data[i+5+j*3+1])
+			}
+
+			i += 5 + fields*3
+			continue
+		}
+
+		messages[globals[local]]++
+		i += sizes[local]
+	}
+
+	for global, expected := range map[uint16]int{0: 1, 20: 12, 19: 2, 18: 2, 34: 1} {
+		if messages[global] != expected {
+			t.Errorf("expected %d messages of type %d, got %d", expected, global, messages[global])
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package hassgpx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// FIT base types.
+const (
+	fitEnum    byte = 0x00
+	fitUint16  byte = 0x84
+	fitSint32  byte = 0x85
+	fitUint32  byte = 0x86
+	fitUint32z byte = 0x8C
+)
+
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+var fitCRCTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+func fitCRC(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := fitCRCTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCRCTable[b&0xF]
+		tmp = fitCRCTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCRCTable[(b>>4)&0xF]
+	}
+
+	return crc
+}
+
+type fitField struct {
+	num, baseType byte
+}
+
+func (f fitField) size() byte {
+	switch f.baseType {
+	case fitUint16:
+		return 2
+	case fitSint32, fitUint32, fitUint32z:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// fitMessage is a message definition bound to a local message type.
+type fitMessage struct {
+	local  byte
+	global uint16
+	fields []fitField
+}
+
+const (
+	fitTimestamp = 253
+
+	fitSportGeneric = 0
+	fitSportRunning = 1
+	fitSportCycling = 2
+	fitSportWalking = 11
+
+	fitEventStop = 1
+)
+
+var (
+	fitFileIDMessage = &fitMessage{local: 0, global: 0, fields: []fitField{
+		{0, fitEnum},    // type
+		{1, fitUint16},  // manufacturer
+		{2, fitUint16},  // product
+		{3, fitUint32z}, // serial_number
+		{4, fitUint32},  // time_created
+	}}
+
+	fitRecordMessage = &fitMessage{local: 1, global: 20, fields: []fitField{
+		{fitTimestamp, fitUint32},
+		{0, fitSint32}, // position_lat
+		{1, fitSint32}, // position_long
+		{2, fitUint16}, // altitude
+		{5, fitUint32}, // distance
+		{6, fitUint16}, // speed
+	}}
+
+	fitLapMessage = &fitMessage{local: 2, global: 19, fields: []fitField{
+		{fitTimestamp, fitUint32},
+		{0, fitEnum},   // event
+		{1, fitEnum},   // event_type
+		{2, fitUint32}, // start_time
+		{7, fitUint32}, // total_elapsed_time
+		{8, fitUint32}, // total_timer_time
+		{9, fitUint32}, // total_distance
+		{25, fitEnum},  // sport
+	}}
+
+	fitSessionMessage = &fitMessage{local: 3, global: 18, fields: []fitField{
+		{fitTimestamp, fitUint32},
+		{0, fitEnum},    // event
+		{1, fitEnum},    // event_type
+		{2, fitUint32},  // start_time
+		{5, fitEnum},    // sport
+		{7, fitUint32},  // total_elapsed_time
+		{8, fitUint32},  // total_timer_time
+		{9, fitUint32},  // total_distance
+		{25, fitUint16}, // first_lap_index
+		{26, fitUint16}, // num_laps
+	}}
+
+	fitActivityMessage = &fitMessage{local: 4, global: 34, fields: []fitField{
+		{fitTimestamp, fitUint32},
+		{0, fitUint32}, // total_timer_time
+		{1, fitUint16}, // num_sessions
+		{2, fitEnum},   // type
+		{3, fitEnum},   // event
+		{4, fitEnum},   // event_type
+	}}
+)
+
+// fitWriter writes FIT messages, emitting message definitions on first use.
+type fitWriter struct {
+	data    bytes.Buffer
+	defined map[*fitMessage]bool
+}
+
+func (w *fitWriter) write(msg *fitMessage, values ...uint32) {
+	if !w.defined[msg] {
+		w.data.WriteByte(0x40 | msg.local)
+		w.data.Write([]byte{0, 0}) // reserved, little endian architecture
+		_ = binary.Write(&w.data, binary.LittleEndian, msg.global)
+		w.data.WriteByte(byte(len(msg.fields)))
+		for _, field := range msg.fields {
+			w.data.Write([]byte{field.num, field.size(), field.baseType})
+		}
+
+		w.defined[msg] = true
+	}
+
+	w.data.WriteByte(msg.local)
+	for i, field := range msg.fields {
+		switch field.size() {
+		case 1:
+			w.data.WriteByte(byte(values[i]))
+		case 2:
+			_ = binary.Write(&w.data, binary.LittleEndian, uint16(values[i]))
+		default:
+			_ = binary.Write(&w.data, binary.LittleEndian, values[i])
+		}
+	}
+}
+
+func fitTime(value time.Time) uint32 {
+	return uint32(value.Sub(fitEpoch) / time.Second)
+}
+
+func fitSemicircles(degrees float64) uint32 {
+	return uint32(int32(math.Round(degrees * (1 << 31) / 180)))
+}
+
+func fitSport(activity string) uint32 {
+	switch activity {
+	case "walk":
+		return fitSportWalking
+	case "run":
+		return fitSportRunning
+	case "bicycle":
+		return fitSportCycling
+	default:
+		return fitSportGeneric
+	}
+}
+
+type fitEncoder struct{}
+
+func (fitEncoder) Extension() string {
+	return "fit"
+}
+
+func (fitEncoder) Encode(w io.Writer, tracks []Track) error {
+	writer := &fitWriter{defined: make(map[*fitMessage]bool)}
+	first := tracks[0].Segment.Waypoints[0].Time
+	writer.write(fitFileIDMessage, 4 /* activity */, 255 /* development */, 0, 1, fitTime(first))
+
+	var totalTime uint32
+	var end time.Time
+	for i, track := range tracks {
+		waypoints := track.Segment.Waypoints
+		start := waypoints[0].Time
+		end = waypoints[len(waypoints)-1].Time
+		var distance float64
+		for j, waypoint := range waypoints {
+			if j > 0 {
+				distance += haversine(waypoints[j-1], waypoint)
+			}
+
+			altitude, speed := uint32(math.MaxUint16), uint32(math.MaxUint16)
+			if waypoint.Elevation != nil {
+				altitude = uint32(math.Round((*waypoint.Elevation + 500) * 5))
+			}
+
+			if waypoint.Extensions != nil && waypoint.Extensions.TrackPoint.Speed != nil {
+				speed = uint32(math.Round(*waypoint.Extensions.TrackPoint.Speed * 1000))
+			}
+
+			writer.write(fitRecordMessage,
+				fitTime(waypoint.Time),
+				fitSemicircles(waypoint.Latitude),
+				fitSemicircles(waypoint.Longitude),
+				altitude,
+				uint32(math.Round(distance*100)),
+				speed)
+		}
+
+		elapsed := uint32(end.Sub(start) / time.Millisecond)
+		totalTime += elapsed
+		sport := fitSport(track.Type)
+		writer.write(fitLapMessage,
+			fitTime(end), 9 /* lap */, fitEventStop, fitTime(start), elapsed, elapsed,
+			uint32(math.Round(distance*100)), sport)
+		writer.write(fitSessionMessage,
+			fitTime(end), 8 /* session */, fitEventStop, fitTime(start), sport, elapsed, elapsed,
+			uint32(math.Round(distance*100)), uint32(i), 1)
+	}
+
+	writer.write(fitActivityMessage,
+		fitTime(end), totalTime, uint32(len(tracks)), 0 /* manual */, 26 /* activity */, fitEventStop)
+
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = 0x20 // protocol version 2.0
+	binary.LittleEndian.PutUint16(header[2:], 2132)
+	binary.LittleEndian.PutUint32(header[4:], uint32(writer.data.Len()))
+	copy(header[8:], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:], fitCRC(0, header[:12]))
+
+	crc := fitCRC(fitCRC(0, header), writer.data.Bytes())
+	for _, data := range [][]byte{header, writer.data.Bytes(), {byte(crc), byte(crc >> 8)}} {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,39 +3,69 @@ package hassgpx
 import (
 	"context"
 	"fmt"
+	"image/png"
+	"strconv"
 	"strings"
 	"time"
-
-	"homebot/common"
+	"unicode/utf8"
 
 	"github.com/jfk9w-go/flu"
 	"github.com/jfk9w-go/flu/apfel"
 	"github.com/jfk9w-go/flu/colf"
 	"github.com/jfk9w-go/flu/syncf"
 	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext"
 	"github.com/jfk9w-go/telegram-bot-api/ext/tapp"
 	"github.com/pkg/errors"
 )
 
 type (
 	Config struct {
-		DB           apfel.GormConfig       `yaml:"db" doc:"Home Assistant database connection settings. This is used for collecting tracking data from Home Assistant."`
-		MaxSpeed     float64                `yaml:"maxSpeed,omitempty" doc:"Maximum speed to be considered \"in track\". This is a really rough approximation via coordinate and time change between two consecutive tracking points,\nand is used to distinguish between using bicycle and other vehicles (like city trains when you forget to turn off tracking – again, REALLY rough approximation)." default:"55"`
-		LastDays     int                    `yaml:"lastDays,omitempty" doc:"Number of full past days to detect bicycle tracks over. 0 means 'today'." default:"0"`
+		Source       string                 `yaml:"source,omitempty" doc:"Tracking data source: Home Assistant recorder database (db) or REST API history (api)." enum:"db,api" default:"db"`
+		DB           apfel.GormConfig       `yaml:"db,omitempty" doc:"Home Assistant database connection settings. This is used for collecting tracking data from Home Assistant with db source."`
+		API          APIConfig              `yaml:"api,omitempty" doc:"Home Assistant REST API connection settings. This is used for collecting tracking data from Home Assistant with api source."`
+		MaxSpeed     float64                `yaml:"maxSpeed,omitempty" doc:"Maximum speed (km/h) to be considered \"in track\". Speed is calculated via geodesic distance between tracking points and smoothed over speedWindow,\nand is used to distinguish between using bicycle and other vehicles (like city trains when you forget to turn off tracking)." default:"55"`
+		LastDays     int                    `yaml:"lastDays,omitempty" doc:"Number of full past days to detect tracks over when no period argument is passed. 0 means 'today'." default:"0"`
 		MoveInterval flu.Duration           `yaml:"moveInterval,omitempty" doc:"If two consecutive tracking points are within this time interval, they are considered to be 'in track'.\nYou need to turn on frequent location updates in Home Assistant app on your phone in order to start 'tracking'." default:"1m" format:"duration"`
-		Users        map[telegram.ID]string `yaml:"users" doc:"Telegram user ID to Home Assistant device name filter mapping. Only users with IDs from this dictionary will be allowed to execute /get_gpx_track."`
+		SpeedWindow  flu.Duration           `yaml:"speedWindow,omitempty" doc:"Sliding time window used for speed smoothing." default:"1m" format:"duration"`
+		MaxAccuracy  float64                `yaml:"maxAccuracy,omitempty" doc:"Tracking points with GPS accuracy (in meters) above this value are skipped. Points without reported accuracy are kept. 0 means no limit." default:"50"`
+		RideGap      flu.Duration           `yaml:"rideGap,omitempty" doc:"Tracks are split into separate rides wherever two consecutive tracking points are further apart than this interval." default:"10m" format:"duration"`
+		Activities   []Activity             `yaml:"activities,omitempty" doc:"Activity profiles used for ride classification by average moving speed. Walk, run, bicycle and car profiles are used by default.\nNote that tracking points faster than maxSpeed are dropped anyway, so you may want to raise it for car rides."`
+		Format       string                 `yaml:"format,omitempty" doc:"Default track export format. It may be overridden with /get_gpx_track argument." enum:"gpx,kml,geojson,tcx,fit" default:"gpx"`
+		TileCache    string                 `yaml:"tileCache,omitempty" doc:"Directory with locally cached map tiles in {z}/{x}/{y}.png layout. It is used as a background for track preview images.\nIf not set, the preview is drawn on a blank background."`
+		TimeZone     string                 `yaml:"timeZone,omitempty" doc:"Time zone used for day boundaries in /get_gpx_track arguments and for displaying ride times." default:"UTC"`
+		PrivacyZones []PrivacyZone          `yaml:"privacyZones,omitempty" doc:"Tracking points inside these zones are cut from the start and the end of each exported ride.\nEach zone is either a Home Assistant zone name or a center point with radius."`
+		PrivacyBlur  bool                   `yaml:"privacyBlur,omitempty" doc:"Also cut tracking points inside privacy zones from the middle of rides."`
+		Delivery     Delivery               `yaml:"delivery,omitempty" doc:"Automatic delivery of finished rides to users in the default format."`
+		Users        map[telegram.ID]string `yaml:"users" doc:"Telegram user ID to Home Assistant device name filter mapping (SQL LIKE pattern for db source, exact entity ID like device_tracker.phone or person.me for api source). Only users with IDs from this dictionary will be allowed to execute /get_gpx_track."`
 	}
 
-	Context interface{ HassGPXConfig() Config }
+	Context interface {
+		tapp.Context
+		HassGPXConfig() Config
+	}
 )
 
 type Mixin[C Context] struct {
 	clock        syncf.Clock
-	storage      Storage[C]
+	storage      StorageInterface
 	users        map[telegram.ID]string
 	maxSpeed     float64
 	lastDays     int
 	moveInterval time.Duration
+	rideGap      time.Duration
+	maxAccuracy  float64
+	speedWindow  time.Duration
+	activities   []Activity
+	location     *time.Location
+	format       string
+	tileCache    string
+	privacyZones []PrivacyZone
+	privacyBlur  bool
+	telegram     tapp.Mixin[C]
+	delivery     Delivery
+	entities     map[string][]telegram.ID
+	delivered    map[deliveryKey]time.Time
 }
 
 func (m *Mixin[C]) String() string {
@@ -43,18 +73,93 @@ func (m *Mixin[C]) String() string {
 }
 
 func (m *Mixin[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
-	if err := app.Use(ctx, &m.storage, false); err != nil {
-		return err
+	config := app.Config().HassGPXConfig()
+	switch config.Source {
+	case "", "db":
+		storage := new(Storage[C])
+		if err := app.Use(ctx, storage, false); err != nil {
+			return err
+		}
+
+		m.storage = storage
+	case "api":
+		storage := new(APIStorage[C])
+		if err := app.Use(ctx, storage, false); err != nil {
+			return err
+		}
+
+		m.storage = storage
+	default:
+		return errors.Errorf("unknown source [%s]", config.Source)
 	}
 
-	config := app.Config().HassGPXConfig()
 	m.users = config.Users
 	m.maxSpeed = config.MaxSpeed
 	m.lastDays = config.LastDays
 	m.moveInterval = config.MoveInterval.Value
+	m.rideGap = config.RideGap.Value
+	m.maxAccuracy = config.MaxAccuracy
+	m.speedWindow = config.SpeedWindow.Value
+	m.activities = config.Activities
+	if len(m.activities) == 0 {
+		m.activities = defaultActivities
+	}
+
+	names := make(colf.Set[string], len(m.activities))
+	for _, activity := range m.activities {
+		if _, ok := encoders[activity.Name]; activity.Name == "" || names[activity.Name] || ok {
+			return errors.Errorf("activity names must be non-empty, unique and differ from format names")
+		}
+
+		names.Add(activity.Name)
+	}
 
+	location, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		return errors.Wrap(err, "load time zone")
+	}
+
+	m.location = location
+	m.format = config.Format
+	m.tileCache = config.TileCache
+	if _, ok := encoders[m.format]; !ok {
+		return errors.Errorf("unknown format [%s]", m.format)
+	}
+	for _, zone := range config.PrivacyZones {
+		if zone.Name == "" && zone.Radius <= 0 {
+			return errors.New("privacy zone must have either name or positive radius")
+		}
+	}
+
+	m.privacyZones = config.PrivacyZones
+	m.privacyBlur = config.PrivacyBlur
 	m.clock = app
 
+	m.delivery = config.Delivery
+	if interval := m.delivery.Interval.Value; interval > 0 {
+		// a ride may be continued after a shorter idle time, and the continuation would never be delivered
+		if m.delivery.IdleTime.Value < m.rideGap {
+			return errors.New("delivery idle time must not be less than ride gap")
+		}
+
+		if err := app.Use(ctx, &m.telegram, false); err != nil {
+			return err
+		}
+
+		m.entities = make(map[string][]telegram.ID)
+		m.delivered = make(map[deliveryKey]time.Time)
+		for userID, entityID := range m.users {
+			m.entities[entityID] = append(m.entities[entityID], userID)
+			m.delivered[deliveryKey{entityID, userID}] = app.Now()
+		}
+
+		if err := app.Manage(ctx, scheduler(syncf.GoSync(context.Background(), func(ctx context.Context) {
+			m.deliver(ctx, interval)
+		}))); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -69,49 +174,222 @@ func (m *Mixin[C]) CommandScope() tapp.CommandScope {
 
 //goland:noinspection GoSnakeCaseUsage
 func (m *Mixin[C]) Get_GPX_track(ctx context.Context, client telegram.Client, cmd *telegram.Command) error {
-	entityID := m.users[cmd.User.ID]
-	since := m.clock.Now().Add(-time.Duration(m.lastDays) * 24 * time.Hour)
-	since = common.TrimDate(since)
-	waypoints, err := m.storage.GetLastTrack(ctx, entityID, since, m.maxSpeed, m.moveInterval)
+	now := m.clock.Now().In(m.location)
+	since, until := startOfDay(now).AddDate(0, 0, -m.lastDays), now
+	activity, format := "", m.format
+	for _, arg := range cmd.Args {
+		if isPeriod(arg) {
+			var err error
+			if since, until, err = parsePeriod(arg, now); err != nil {
+				return err
+			}
+		} else if _, ok := encoders[arg]; ok {
+			format = arg
+		} else if m.isActivity(arg) {
+			activity = arg
+		} else {
+			return errors.Errorf("unknown activity or format [%s]", arg)
+		}
+	}
+
+	rides, err := m.getRides(ctx, m.users[cmd.User.ID], since, until, activity)
+	if err != nil {
+		return err
+	}
+
+	if len(rides) == 1 {
+		return m.sendRides(ctx, client, cmd.Chat.ID, rides, format)
+	}
+
+	buttons := make([][]telegram.Button, 0, len(rides)+1)
+	for _, ride := range rides {
+		text := m.classify(ride) + " " + ride.Start().In(m.location).Format("02.01 15:04") +
+			" – " + ride.End().In(m.location).Format("15:04")
+		buttons = append(buttons, []telegram.Button{rideButton(text, ride, ride, format, activity)})
+	}
+
+	buttons = append(buttons, []telegram.Button{rideButton("All rides", rides[0], rides[len(rides)-1], format, activity)})
+	if _, err := client.Send(ctx, cmd.Chat.ID,
+		&telegram.Text{Text: fmt.Sprintf("Found %d rides, pick one:", len(rides))},
+		&telegram.SendOptions{ReplyMarkup: telegram.InlineKeyboard(buttons...)},
+	); err != nil {
+		return errors.Wrap(err, "send rides keyboard")
+	}
+
+	return nil
+}
+
+const rideCallbackKey = "gpx_ride"
+
+func rideButton(text string, first, last Ride, format, activity string) telegram.Button {
+	args := fmt.Sprintf("%d %d %s %s", first.Start().Unix(), last.End().Unix(), format, activity)
+	return telegram.Button{text, rideCallbackKey, strings.TrimSpace(args)}
+}
+
+//goland:noinspection GoSnakeCaseUsage
+func (m *Mixin[C]) Gpx_ride_callback(ctx context.Context, client telegram.Client, cmd *telegram.Command) error {
+	start, err := strconv.ParseInt(cmd.Arg(0), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "parse ride start")
+	}
+
+	end, err := strconv.ParseInt(cmd.Arg(1), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "parse ride end")
+	}
+
+	rides, err := m.getRides(ctx, m.users[cmd.User.ID], time.Unix(start, 0), time.Unix(end+1, 0), cmd.Arg(3))
+	if err != nil {
+		return err
+	}
+
+	if err := m.sendRides(ctx, client, cmd.Chat.ID, rides, cmd.Arg(2)); err != nil {
+		return err
+	}
+
+	return cmd.ReplyCallback(ctx, client, "OK")
+}
+
+func (m *Mixin[C]) isActivity(name string) bool {
+	for _, activity := range m.activities {
+		if activity.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Mixin[C]) classify(ride Ride) string {
+	return classify(m.activities, rideStats(ride).AvgSpeed())
+}
+
+func (m *Mixin[C]) getRides(ctx context.Context, entityID string, since, until time.Time, activity string) ([]Ride, error) {
+	rides, err := m.findRides(ctx, entityID, since, until, activity)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rides) == 0 {
+		return nil, errors.New("no recent tracks")
+	}
+
+	return rides, nil
+}
+
+func (m *Mixin[C]) findRides(ctx context.Context, entityID string, since, until time.Time, activity string) ([]Ride, error) {
+	points, err := m.storage.GetPoints(ctx, entityID, since.Add(-m.moveInterval), until, m.maxAccuracy)
+	if err != nil {
+		return nil, errors.Wrap(err, "get points")
+	}
+
+	track := filterTrack(points, m.maxSpeed, m.moveInterval, m.speedWindow)
+	for len(track) > 0 && track[0].Time.Before(since) {
+		track = track[1:]
+	}
+
+	rides := splitRides(track, m.rideGap)
+	if activity != "" {
+		filtered := rides[:0]
+		for _, ride := range rides {
+			if m.classify(ride) == activity {
+				filtered = append(filtered, ride)
+			}
+		}
+
+		rides = filtered
+	}
+
+	return rides, nil
+}
+
+func (m *Mixin[C]) sendRides(ctx context.Context, client telegram.Client, chatID telegram.ID, rides []Ride, format string) error {
+	encoder, ok := encoders[format]
+	if !ok {
+		return errors.Errorf("unknown format [%s]", format)
+	}
+
+	zones, err := m.getPrivacyZones(ctx)
 	if err != nil {
-		return errors.Wrap(err, "get last track")
+		return err
+	}
+
+	visible := make([]Ride, 0, len(rides))
+	for _, ride := range rides {
+		if ride := hideZones(ride, zones, m.privacyBlur); len(ride) > 0 {
+			visible = append(visible, ride)
+		}
 	}
 
-	if len(waypoints) == 0 {
-		return errors.New("no recent tracks")
+	if len(visible) == 0 {
+		return errRideHidden
 	}
 
-	gpx := &GPX{
-		XMLNS:          "https://www.topografix.com/GPX/1/1",
-		Creator:        "github.com/jfk9w-go/homebot",
-		Version:        "1.1",
-		XSI:            "https://www.w3.org/2001/XMLSchema-instance",
-		SchemaLocation: "https://www.topografix.com/GPX/1/1 https://www.topografix.com/GPX/1/1/gpx.xsd",
-		Metadata: Metadata{
-			Name: waypoints[0].Time.String(),
-			Desc: fmt.Sprintf("%s – %s", waypoints[0].Time, waypoints[len(waypoints)-1].Time),
-		},
-		Track: Track{
+	rides = visible
+	tracks := make([]Track, len(rides))
+	captions := make([]string, len(rides))
+	for i, ride := range rides {
+		stats := rideStats(ride)
+		activity := classify(m.activities, stats.AvgSpeed())
+		captions[i] = activity + ": " + stats.String()
+		if len(rides) > 1 {
+			captions[i] = ride.Start().In(m.location).Format("15:04") + " " + captions[i]
+		}
+
+		tracks[i] = Track{
+			Metadata: Metadata{
+				Name: ride.Start().String(),
+				Desc: fmt.Sprintf("%s – %s", ride.Start(), ride.End()),
+			},
+			Type: activity,
 			Segment: TrackSegment{
-				Waypoints: waypoints,
+				Waypoints: ride,
 			},
-		},
+		}
 	}
 
 	buffer := new(flu.ByteBuffer)
-	if err := flu.EncodeTo(flu.XML(gpx), buffer); err != nil {
-		return errors.Wrap(err, "encode value")
+	if err := encoder.Encode(buffer.Unmask(), tracks); err != nil {
+		return errors.Wrapf(err, "encode %s", format)
 	}
 
-	filename := strings.Replace(waypoints[0].Time.String(), ":", "_", -1) + ".gpx"
-	if _, err := client.Send(ctx, cmd.Chat.ID,
+	// stats of many rides do not fit into a caption, so they are sent as a separate message
+	caption, stats := strings.Join(captions, "\n"), ""
+	if utf8.RuneCountInString(caption) > telegram.MaxCaptionSize {
+		caption, stats = "", caption
+	}
+
+	filename := strings.Replace(rides[0].Start().String(), ":", "_", -1) + "." + encoder.Extension()
+	if _, err := client.Send(ctx, chatID,
 		&telegram.Media{
 			Type:     telegram.Document,
 			Input:    buffer,
 			Filename: filename,
+			Caption:  caption,
+		}, nil,
+	); err != nil {
+		return errors.Wrapf(err, "send %s track", format)
+	}
+
+	if stats != "" {
+		if err := ext.HTML(ctx, client, chatID).Text(stats).Flush(); err != nil {
+			return errors.Wrap(err, "send stats")
+		}
+	}
+
+	preview := new(flu.ByteBuffer)
+	if err := png.Encode(preview.Unmask(), renderPreview(rides, m.tileCache)); err != nil {
+		return errors.Wrap(err, "encode preview")
+	}
+
+	if _, err := client.Send(ctx, chatID,
+		&telegram.Media{
+			Type:     telegram.Photo,
+			Input:    preview,
+			Filename: "preview.png",
 		}, nil,
 	); err != nil {
-		return errors.Wrap(err, "send gpx track")
+		return errors.Wrap(err, "send preview")
 	}
 
 	return nil
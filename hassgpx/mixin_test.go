@@ -0,0 +1,57 @@
+package hassgpx
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+func TestSendRides_LongCaption(t *testing.T) {
+	track := newTrackBuilder(55.75, 37.61)
+	for i := 0; i < 20; i++ {
+		if i > 0 {
+			track.pause(20 * time.Minute)
+		}
+
+		track.north(18, 10)
+	}
+
+	rides := splitRides(track.points, 10*time.Minute)
+	if len(rides) != 20 {
+		t.Fatalf("expected 20 rides, got %d", len(rides))
+	}
+
+	ctx := context.Background()
+	m := newTestMixin(track.points, track.last().Time)
+	client := new(testClient)
+	if err := m.sendRides(ctx, client, 1, rides, "gpx"); err != nil {
+		t.Fatal(err)
+	}
+
+	// document, stats and preview
+	if len(client.sent) != 3 {
+		t.Fatalf("expected 3 sent items, got %d", len(client.sent))
+	}
+
+	document, ok := client.sent[0].(*telegram.Media)
+	if !ok || document.Type != telegram.Document {
+		t.Fatalf("expected document, got %+v", client.sent[0])
+	}
+
+	if length := utf8.RuneCountInString(document.Caption); length > telegram.MaxCaptionSize {
+		t.Errorf("expected caption to fit into %d characters, got %d", telegram.MaxCaptionSize, length)
+	}
+
+	stats, ok := client.sent[1].(*telegram.Text)
+	if !ok {
+		t.Fatalf("expected stats text, got %+v", client.sent[1])
+	}
+
+	if lines := strings.Count(stats.Text, "\n") + 1; lines != len(rides) {
+		t.Errorf("expected stats for %d rides, got %d lines", len(rides), lines)
+	}
+}
@@ -12,10 +12,11 @@ type GPX struct {
 	Creator        string `xml:"creator,attr"`
 	Version        string `xml:"version,attr"`
 	XSI            string `xml:"xmlns:xsi,attr"`
+	TPX            string `xml:"xmlns:gpxtpx,attr"`
 	SchemaLocation string `xml:"xsi:schemaLocation,attr"`
 
 	Metadata Metadata `xml:"metadata"`
-	Track    Track    `xml:"trk"`
+	Tracks   []Track  `xml:"trk"`
 }
 
 type Metadata struct {
@@ -25,6 +26,7 @@ type Metadata struct {
 
 type Track struct {
 	Metadata
+	Type    string       `xml:"type,omitempty"`
 	Segment TrackSegment `xml:"trkseg"`
 }
 
@@ -33,8 +35,19 @@ type TrackSegment struct {
 }
 
 type Waypoint struct {
-	Latitude  float64   `xml:"lat,attr"`
-	Longitude float64   `xml:"lon,attr"`
-	Elevation float64   `xml:"ele"`
-	Time      time.Time `xml:"time"`
+	Latitude   float64             `xml:"lat,attr"`
+	Longitude  float64             `xml:"lon,attr"`
+	Elevation  *float64            `xml:"ele,omitempty"`
+	Time       time.Time           `xml:"time"`
+	Extensions *WaypointExtensions `xml:"extensions,omitempty"`
+}
+
+type WaypointExtensions struct {
+	TrackPoint TrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+}
+
+// TrackPointExtension is a subset of Garmin TrackPointExtension v2 schema.
+type TrackPointExtension struct {
+	Speed  *float64 `xml:"gpxtpx:speed,omitempty"`
+	Course *float64 `xml:"gpxtpx:course,omitempty"`
 }
@@ -0,0 +1,41 @@
+package hassgpx
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaypoint_MarshalXML(t *testing.T) {
+	waypoint := Waypoint{
+		Latitude:  55.1,
+		Longitude: 37.2,
+		Elevation: float(150),
+		Time:      time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+		Extensions: &WaypointExtensions{
+			TrackPoint: TrackPointExtension{Speed: float(4.5), Course: float(90)},
+		},
+	}
+
+	data, err := xml.Marshal(waypoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<ele>150</ele><time>2022-06-01T10:00:00Z</time>` +
+		`<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>4.5</gpxtpx:speed><gpxtpx:course>90</gpxtpx:course>` +
+		`</gpxtpx:TrackPointExtension></extensions>`
+	if !strings.Contains(string(data), expected) {
+		t.Errorf("expected %s to contain %s", data, expected)
+	}
+
+	data, err = xml.Marshal(Waypoint{Time: waypoint.Time})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "<ele>") || strings.Contains(string(data), "<extensions>") {
+		t.Errorf("expected empty fields to be omitted, got %s", data)
+	}
+}
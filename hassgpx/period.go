@@ -0,0 +1,74 @@
+package hassgpx
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const dateLayout = "2006-01-02"
+
+func startOfDay(value time.Time) time.Time {
+	return time.Date(value.Year(), value.Month(), value.Day(), 0, 0, 0, 0, value.Location())
+}
+
+// isPeriod checks if the value looks like a period argument (as opposed to activity name).
+func isPeriod(value string) bool {
+	return strings.HasPrefix(value, "..") || value != "" && (value[0] == '-' || value[0] >= '0' && value[0] <= '9')
+}
+
+// parsePeriod parses a date (2006-01-02), a date range (2006-01-02..2006-01-03, either side may be omitted)
+// or an offset (-3d, -12h) relative to now. Day boundaries are calculated in now's location.
+func parsePeriod(value string, now time.Time) (since, until time.Time, err error) {
+	location := now.Location()
+	switch {
+	case strings.HasPrefix(value, "-"):
+		if strings.HasSuffix(value, "d") {
+			n, err := strconv.Atoi(value[1 : len(value)-1])
+			if err != nil || n < 0 {
+				return since, until, errors.Errorf("invalid day offset [%s]", value)
+			}
+
+			return startOfDay(now).AddDate(0, 0, -n), now, nil
+		}
+
+		offset, err := time.ParseDuration(value[1:])
+		if err != nil || offset < 0 {
+			return since, until, errors.Errorf("invalid offset [%s]", value)
+		}
+
+		return now.Add(-offset), now, nil
+
+	case strings.Contains(value, ".."):
+		from, to, _ := strings.Cut(value, "..")
+		since, until = time.Time{}, now
+		if from != "" {
+			if since, err = time.ParseInLocation(dateLayout, from, location); err != nil {
+				return since, until, errors.Errorf("invalid date [%s]", from)
+			}
+		}
+
+		if to != "" {
+			if until, err = time.ParseInLocation(dateLayout, to, location); err != nil {
+				return since, until, errors.Errorf("invalid date [%s]", to)
+			}
+
+			until = until.AddDate(0, 0, 1)
+		}
+
+		if !since.Before(until) {
+			return since, until, errors.Errorf("empty period [%s]", value)
+		}
+
+		return since, until, nil
+
+	default:
+		if since, err = time.ParseInLocation(dateLayout, value, location); err != nil {
+			return since, until, errors.Errorf("invalid date [%s], expected %s, %s..%s or -3d", value, dateLayout, dateLayout, dateLayout)
+		}
+
+		return since, since.AddDate(0, 0, 1), nil
+	}
+}
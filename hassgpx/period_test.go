@@ -0,0 +1,60 @@
+package hassgpx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+)
+
+func TestParsePeriod(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2022, 6, 15, 1, 30, 0, 0, moscow)
+	date := func(day int) time.Time { return time.Date(2022, 6, day, 0, 0, 0, 0, moscow) }
+	for value, expected := range map[string][2]time.Time{
+		"2022-06-10":             {date(10), date(11)},
+		"2022-06-10..2022-06-12": {date(10), date(13)},
+		"2022-06-10..":           {date(10), now},
+		"-3d":                    {date(12), now},
+		"-0d":                    {date(15), now},
+		"-12h":                   {now.Add(-12 * time.Hour), now},
+	} {
+		since, until, err := parsePeriod(value, now)
+		if err != nil {
+			t.Errorf("parse %s: %v", value, err)
+			continue
+		}
+
+		if !since.Equal(expected[0]) || !until.Equal(expected[1]) {
+			t.Errorf("expected %s to be [%s, %s), got [%s, %s)", value, expected[0], expected[1], since, until)
+		}
+	}
+
+	for _, value := range []string{"2022-13-01", "-xd", "2022-06-12..2022-06-10", "-1y"} {
+		if _, _, err := parsePeriod(value, now); err == nil {
+			t.Errorf("expected %s to be invalid", value)
+		}
+	}
+}
+
+func TestGet_GPX_track_OpenStartRange(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.61).north(18, 60).points
+	m := newTestMixin(points, time.Date(2022, 6, 5, 12, 0, 0, 0, time.UTC))
+	command := func(args ...string) *telegram.Command {
+		return &telegram.Command{Chat: &telegram.Chat{ID: 1}, User: &telegram.User{ID: 1}, Args: args}
+	}
+
+	client := new(testClient)
+	if err := m.Get_GPX_track(context.Background(), client, command("..2022-06-03")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.sent) != 2 {
+		t.Errorf("expected ride document and preview to be sent, got %d sent items", len(client.sent))
+	}
+
+	if err := m.Get_GPX_track(context.Background(), client, command("..2022-05-31", "kml")); err == nil || err.Error() != "no recent tracks" {
+		t.Errorf("expected no tracks before the range end, got %v", err)
+	}
+}
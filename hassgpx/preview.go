@@ -0,0 +1,153 @@
+package hassgpx
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	previewSize    = 512
+	previewPadding = 32
+	tileSize       = 256
+	maxZoom        = 18
+)
+
+var (
+	previewBackground = color.RGBA{R: 0xF2, G: 0xEF, B: 0xE9, A: 0xFF}
+	previewTrack      = color.RGBA{R: 0x1E, G: 0x64, B: 0xE6, A: 0xFF}
+	previewStart      = color.RGBA{R: 0x2E, G: 0xA0, B: 0x43, A: 0xFF}
+	previewFinish     = color.RGBA{R: 0xD9, G: 0x30, B: 0x25, A: 0xFF}
+)
+
+// project converts coordinates to Web Mercator world pixel coordinates at the zoom level.
+func project(waypoint Waypoint, zoom int) (x, y float64) {
+	scale := tileSize * math.Exp2(float64(zoom))
+	lat := waypoint.Latitude * math.Pi / 180
+	x = (waypoint.Longitude + 180) / 360 * scale
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * scale
+	return
+}
+
+type preview struct {
+	*image.RGBA
+	zoom             int
+	originX, originY float64
+}
+
+func (p *preview) point(waypoint Waypoint) (float64, float64) {
+	x, y := project(waypoint, p.zoom)
+	return x - p.originX, y - p.originY
+}
+
+func newPreview(rides []Ride) *preview {
+	zoom := maxZoom
+	var minX, minY, maxX, maxY float64
+	for ; zoom >= 0; zoom-- {
+		minX, minY, maxX, maxY = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, ride := range rides {
+			for _, waypoint := range ride {
+				x, y := project(waypoint, zoom)
+				minX, minY = math.Min(minX, x), math.Min(minY, y)
+				maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+			}
+		}
+
+		if maxX-minX <= previewSize-2*previewPadding && maxY-minY <= previewSize-2*previewPadding {
+			break
+		}
+	}
+
+	return &preview{
+		RGBA:    image.NewRGBA(image.Rect(0, 0, previewSize, previewSize)),
+		zoom:    zoom,
+		originX: (minX+maxX)/2 - previewSize/2,
+		originY: (minY+maxY)/2 - previewSize/2,
+	}
+}
+
+// drawTiles draws cached tiles from the {z}/{x}/{y}.png directory layout.
+// Missing or broken tiles are left blank.
+func (p *preview) drawTiles(dir string) {
+	draw.Draw(p, p.Bounds(), image.NewUniform(previewBackground), image.Point{}, draw.Src)
+	if dir == "" {
+		return
+	}
+
+	tiles := 1 << p.zoom
+	for tx := int(p.originX) / tileSize; tx <= int(p.originX+previewSize)/tileSize; tx++ {
+		for ty := int(p.originY) / tileSize; ty <= int(p.originY+previewSize)/tileSize; ty++ {
+			if tx < 0 || ty < 0 || tx >= tiles || ty >= tiles {
+				continue
+			}
+
+			tile, err := loadTile(filepath.Join(dir, fmt.Sprint(p.zoom), fmt.Sprint(tx), fmt.Sprintf("%d.png", ty)))
+			if err != nil {
+				continue
+			}
+
+			at := image.Pt(tx*tileSize-int(p.originX), ty*tileSize-int(p.originY))
+			draw.Draw(p, image.Rectangle{Min: at, Max: at.Add(image.Pt(tileSize, tileSize))}, tile, tile.Bounds().Min, draw.Src)
+		}
+	}
+}
+
+func loadTile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	return png.Decode(file)
+}
+
+func (p *preview) dot(x, y, radius float64, c color.Color) {
+	for dx := -radius; dx <= radius; dx++ {
+		for dy := -radius; dy <= radius; dy++ {
+			if dx*dx+dy*dy <= radius*radius {
+				p.Set(int(math.Round(x+dx)), int(math.Round(y+dy)), c)
+			}
+		}
+	}
+}
+
+func (p *preview) line(x0, y0, x1, y1 float64, c color.Color) {
+	steps := math.Max(math.Abs(x1-x0), math.Abs(y1-y0))
+	for i := 0.0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = i / steps
+		}
+
+		p.dot(x0+(x1-x0)*t, y0+(y1-y0)*t, 1.5, c)
+	}
+}
+
+// renderPreview draws rides as polylines with start and finish markers.
+func renderPreview(rides []Ride, tileDir string) image.Image {
+	p := newPreview(rides)
+	p.drawTiles(tileDir)
+	for _, ride := range rides {
+		for i := 1; i < len(ride); i++ {
+			x0, y0 := p.point(ride[i-1])
+			x1, y1 := p.point(ride[i])
+			p.line(x0, y0, x1, y1, previewTrack)
+		}
+	}
+
+	first, last := rides[0], rides[len(rides)-1]
+	x, y := p.point(first[0])
+	p.dot(x, y, 7, color.White)
+	p.dot(x, y, 5, previewStart)
+	x, y = p.point(last[len(last)-1])
+	p.dot(x, y, 7, color.White)
+	p.dot(x, y, 5, previewFinish)
+
+	return p
+}
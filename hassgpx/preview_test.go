@@ -0,0 +1,75 @@
+package hassgpx
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRenderPreview(t *testing.T) {
+	rides := []Ride{
+		newTrackBuilder(55.75, 37.6).north(20, 30).points,
+		newTrackBuilder(55.76, 37.6).east(20, 30).points,
+	}
+
+	img := renderPreview(rides, "")
+	if size := img.Bounds().Size(); size.X != previewSize || size.Y != previewSize {
+		t.Fatalf("unexpected preview size: %v", size)
+	}
+
+	p := newPreview(rides)
+	for expected, waypoint := range map[color.RGBA]Waypoint{
+		previewStart:  rides[0][0],
+		previewFinish: rides[1][len(rides[1])-1],
+		previewTrack:  rides[0][15],
+	} {
+		x, y := p.point(waypoint)
+		if actual := img.At(int(x), int(y)); actual != expected {
+			t.Errorf("expected %v at (%.0f, %.0f), got %v", expected, x, y, actual)
+		}
+	}
+
+	if actual := img.At(0, 0); actual != previewBackground {
+		t.Errorf("expected blank background, got %v", actual)
+	}
+}
+
+func TestRenderPreview_Tiles(t *testing.T) {
+	rides := []Ride{newTrackBuilder(55.75, 37.6).north(20, 30).points}
+	p := newPreview(rides)
+
+	dir := t.TempDir()
+	tileColor := color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF}
+	tile := image.NewUniform(tileColor)
+	for tx := int(p.originX) / tileSize; tx <= int(p.originX+previewSize)/tileSize; tx++ {
+		for ty := int(p.originY) / tileSize; ty <= int(p.originY+previewSize)/tileSize; ty++ {
+			path := filepath.Join(dir, strconv.Itoa(p.zoom), strconv.Itoa(tx), strconv.Itoa(ty)+".png")
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+			draw.Draw(img, img.Bounds(), tile, image.Point{}, draw.Src)
+			if err := png.Encode(file, img); err != nil {
+				t.Fatal(err)
+			}
+
+			_ = file.Close()
+		}
+	}
+
+	img := renderPreview(rides, dir)
+	if actual := img.At(0, 0); actual != tileColor {
+		t.Errorf("expected tile background, got %v", actual)
+	}
+}
@@ -0,0 +1,89 @@
+package hassgpx
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errRideHidden is returned when all tracking points of a ride are inside privacy zones.
+var errRideHidden = errors.New("all tracking points are inside privacy zones")
+
+// Zone is a circle on the map.
+type Zone struct {
+	Latitude  float64 `yaml:"latitude,omitempty" doc:"Zone center latitude."`
+	Longitude float64 `yaml:"longitude,omitempty" doc:"Zone center longitude."`
+	Radius    float64 `yaml:"radius,omitempty" doc:"Zone radius in meters."`
+}
+
+func (z Zone) contains(waypoint Waypoint) bool {
+	return haversine(Waypoint{Latitude: z.Latitude, Longitude: z.Longitude}, waypoint) <= z.Radius
+}
+
+type PrivacyZone struct {
+	Zone `yaml:"-,inline"`
+	Name string `yaml:"name,omitempty" doc:"Home Assistant zone name (like home or zone.home). If set, zone coordinates and radius are read from Home Assistant."`
+}
+
+func zoneEntityID(name string) string {
+	if strings.HasPrefix(name, "zone.") {
+		return name
+	}
+
+	return "zone." + name
+}
+
+func inZones(zones []Zone, waypoint Waypoint) bool {
+	for _, zone := range zones {
+		if zone.contains(waypoint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hideZones trims waypoints inside zones from the start and the end of the ride.
+// If blur is set, waypoints inside zones are also removed from the middle of the ride.
+func hideZones(ride Ride, zones []Zone, blur bool) Ride {
+	start, end := 0, len(ride)
+	for start < end && inZones(zones, ride[start]) {
+		start++
+	}
+
+	for end > start && inZones(zones, ride[end-1]) {
+		end--
+	}
+
+	ride = ride[start:end]
+	if !blur {
+		return ride
+	}
+
+	visible := make(Ride, 0, len(ride))
+	for _, waypoint := range ride {
+		if !inZones(zones, waypoint) {
+			visible = append(visible, waypoint)
+		}
+	}
+
+	return visible
+}
+
+func (m *Mixin[C]) getPrivacyZones(ctx context.Context) ([]Zone, error) {
+	zones := make([]Zone, len(m.privacyZones))
+	for i, zone := range m.privacyZones {
+		if zone.Name == "" {
+			zones[i] = zone.Zone
+			continue
+		}
+
+		var err error
+		if zones[i], err = m.storage.GetZone(ctx, zoneEntityID(zone.Name)); err != nil {
+			return nil, errors.Wrapf(err, "get zone %s", zone.Name)
+		}
+	}
+
+	return zones, nil
+}
@@ -0,0 +1,33 @@
+package hassgpx
+
+import (
+	"testing"
+)
+
+func TestHideZones(t *testing.T) {
+	// 3 km north with a point each 50 meters
+	ride := Ride(newTrackBuilder(55.75, 37.61).north(18, 60).points)
+	home := Zone{Latitude: 55.75, Longitude: 37.61, Radius: 175}
+	work := Zone{Latitude: ride[60].Latitude, Longitude: 37.61, Radius: 175}
+	shop := Zone{Latitude: ride[30].Latitude, Longitude: 37.61, Radius: 110}
+
+	hidden := hideZones(ride, []Zone{home, work, shop}, false)
+	if len(hidden) != 53 || hidden[0] != ride[4] || hidden[len(hidden)-1] != ride[56] {
+		t.Errorf("expected points 4 to 56 to be visible, got %d points", len(hidden))
+	}
+
+	hidden = hideZones(ride, []Zone{home, work, shop}, true)
+	if len(hidden) != 48 {
+		t.Errorf("expected 48 points to be visible with blur, got %d", len(hidden))
+	}
+
+	for _, waypoint := range hidden {
+		if inZones([]Zone{home, work, shop}, waypoint) {
+			t.Errorf("point %+v is inside privacy zone", waypoint)
+		}
+	}
+
+	if hidden := hideZones(ride, []Zone{{Latitude: 55.76, Longitude: 37.61, Radius: 5000}}, false); len(hidden) != 0 {
+		t.Errorf("expected ride to be hidden completely, got %d points", len(hidden))
+	}
+}
@@ -0,0 +1,33 @@
+package hassgpx
+
+import (
+	"time"
+)
+
+// Ride is a continuous sequence of tracking points.
+type Ride []Waypoint
+
+func (r Ride) Start() time.Time {
+	return r[0].Time
+}
+
+func (r Ride) End() time.Time {
+	return r[len(r)-1].Time
+}
+
+// splitRides splits waypoints into rides wherever the time between two consecutive points exceeds the gap.
+func splitRides(waypoints []Waypoint, gap time.Duration) []Ride {
+	var rides []Ride
+	start := 0
+	for i := 1; i <= len(waypoints); i++ {
+		if i == len(waypoints) || waypoints[i].Time.Sub(waypoints[i-1].Time) > gap {
+			if i > start {
+				rides = append(rides, waypoints[start:i])
+			}
+
+			start = i
+		}
+	}
+
+	return rides
+}
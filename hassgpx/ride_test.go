@@ -0,0 +1,33 @@
+package hassgpx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitRides(t *testing.T) {
+	start := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	var waypoints []Waypoint
+	for _, offset := range []time.Duration{0, time.Minute, 2 * time.Minute, time.Hour, time.Hour + time.Minute, 3 * time.Hour} {
+		waypoints = append(waypoints, Waypoint{Time: start.Add(offset)})
+	}
+
+	rides := splitRides(waypoints, 10*time.Minute)
+	if len(rides) != 3 {
+		t.Fatalf("expected 3 rides, got %d", len(rides))
+	}
+
+	for i, expected := range []int{3, 2, 1} {
+		if len(rides[i]) != expected {
+			t.Errorf("expected ride %d to have %d points, got %d", i, expected, len(rides[i]))
+		}
+	}
+
+	if !rides[1].Start().Equal(start.Add(time.Hour)) || !rides[1].End().Equal(start.Add(time.Hour+time.Minute)) {
+		t.Errorf("unexpected ride bounds: %s – %s", rides[1].Start(), rides[1].End())
+	}
+
+	if rides := splitRides(nil, time.Minute); len(rides) != 0 {
+		t.Errorf("expected no rides, got %d", len(rides))
+	}
+}
@@ -0,0 +1,171 @@
+package hassgpx
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Home Assistant recorder schema versions introducing breaking changes to the states table.
+const (
+	// stateAttributesVersion moves state attributes to state_attributes table.
+	stateAttributesVersion = 25
+	// timestampsVersion replaces timestamp columns with *_ts epoch float columns.
+	timestampsVersion = 31
+	// statesMetaVersion moves entity IDs to states_meta table.
+	statesMetaVersion = 36
+)
+
+// recorderSchema describes Home Assistant recorder database schema.
+// It is used as data for view DDL templates.
+type recorderSchema struct {
+	Version int
+}
+
+func detectRecorderSchema(ctx context.Context, db *gorm.DB) (recorderSchema, error) {
+	var version sql.NullInt64
+	if err := db.WithContext(ctx).Raw( /* language=SQL */ `
+	select max(schema_version)
+	from schema_changes`).
+		Scan(&version).
+		Error; err != nil {
+		return recorderSchema{}, err
+	}
+
+	return recorderSchema{Version: int(version.Int64)}, nil
+}
+
+func (s recorderSchema) StateAttributes() bool {
+	return s.Version >= stateAttributesVersion
+}
+
+func (s recorderSchema) StatesMeta() bool {
+	return s.Version >= statesMetaVersion
+}
+
+// EpochTime returns true if the gps view time column contains epoch seconds.
+func (s recorderSchema) EpochTime() bool {
+	return s.Version >= timestampsVersion
+}
+
+func (s recorderSchema) Time() string {
+	switch {
+	case s.EpochTime():
+		return "states.last_updated_ts"
+	case s.StateAttributes():
+		return "states.last_updated"
+	default:
+		return "states.created"
+	}
+}
+
+func (s recorderSchema) EntityID() string {
+	if s.StatesMeta() {
+		return "coalesce(states_meta.entity_id, states.entity_id)"
+	}
+
+	return "states.entity_id"
+}
+
+func (s recorderSchema) Attributes() string {
+	if s.StateAttributes() {
+		return "coalesce(state_attributes.shared_attrs, states.attributes)"
+	}
+
+	return "states.attributes"
+}
+
+// timeArg converts a time value for comparison with gps view time column.
+func (s recorderSchema) timeArg(value time.Time) any {
+	if s.EpochTime() {
+		return float64(value.UnixNano()) / 1e9
+	}
+
+	return value.UTC()
+}
+
+// recorderViews are created over Home Assistant recorder states table.
+var recorderViews = []string{"gps", "zones"}
+
+// createViews detects Home Assistant recorder schema and creates recorderViews
+// using the DDL for the database driver.
+func createViews(ctx context.Context, db *gorm.DB, driver string) (recorderSchema, error) {
+	if _, err := ddl.ReadDir("ddl/" + driver); err != nil {
+		return recorderSchema{}, errors.Errorf("unsupported database driver [%s]", driver)
+	}
+
+	schema, err := detectRecorderSchema(ctx, db)
+	if err != nil {
+		return recorderSchema{}, errors.Wrap(err, "detect recorder schema version")
+	}
+
+	for _, view := range recorderViews {
+		if err := createView(ctx, db, driver, view, schema); err != nil {
+			return recorderSchema{}, errors.Wrapf(err, "create %s view", view)
+		}
+	}
+
+	return schema, nil
+}
+
+func createView(ctx context.Context, db *gorm.DB, driver, view string, schema recorderSchema) error {
+	text, err := ddl.ReadFile("ddl/" + driver + "/" + view + ".sql")
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(view).Parse(string(text))
+	if err != nil {
+		return errors.Wrap(err, "parse template")
+	}
+
+	var buffer strings.Builder
+	if err := tmpl.Execute(&buffer, schema); err != nil {
+		return errors.Wrap(err, "execute template")
+	}
+
+	for _, statement := range strings.Split(buffer.String(), ";\n") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+
+		if err := db.WithContext(ctx).Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recorderTime scans both datetime and epoch seconds gps view time column values.
+type recorderTime time.Time
+
+func (t *recorderTime) Scan(value any) error {
+	switch value := value.(type) {
+	case time.Time:
+		*t = recorderTime(value)
+	case float64:
+		*t = recorderTime(time.Unix(0, int64(value*1e9)))
+	case int64:
+		*t = recorderTime(time.Unix(value, 0))
+	case []byte:
+		return t.Scan(string(value))
+	case string:
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.Errorf("invalid time value [%s]", value)
+		}
+
+		return t.Scan(seconds)
+	default:
+		return errors.Errorf("unsupported time value type %T", value)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package hassgpx
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	earthRadius = 6371e3 // meters
+
+	// minMovingSpeed is the speed (km/h) below which the time between two points is not considered moving time.
+	minMovingSpeed = 2.0
+)
+
+// haversine returns the great-circle distance between two points in meters.
+func haversine(a, b Waypoint) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat, dLon := lat2-lat1, (b.Longitude-a.Longitude)*math.Pi/180
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+type RideStats struct {
+	Distance      float64 // kilometers
+	MovingTime    time.Duration
+	MaxSpeed      float64 // km/h
+	ElevationGain float64 // meters
+}
+
+// AvgSpeed returns average moving speed in km/h.
+func (s RideStats) AvgSpeed() float64 {
+	if s.MovingTime <= 0 {
+		return 0
+	}
+
+	return s.Distance / s.MovingTime.Hours()
+}
+
+func (s RideStats) String() string {
+	return fmt.Sprintf("%.2f km · %s moving · %.1f km/h avg · %.1f km/h max · ↗ %.0f m",
+		s.Distance, s.MovingTime.Round(time.Second), s.AvgSpeed(), s.MaxSpeed, s.ElevationGain)
+}
+
+func rideStats(ride Ride) RideStats {
+	var stats RideStats
+	for i := 1; i < len(ride); i++ {
+		prev, curr := ride[i-1], ride[i]
+		distance := haversine(prev, curr) / 1000
+		stats.Distance += distance
+
+		if prev.Elevation != nil && curr.Elevation != nil {
+			if elevation := *curr.Elevation - *prev.Elevation; elevation > 0 {
+				stats.ElevationGain += elevation
+			}
+		}
+
+		duration := curr.Time.Sub(prev.Time)
+		if duration <= 0 {
+			continue
+		}
+
+		speed := distance / duration.Hours()
+		if speed >= minMovingSpeed {
+			stats.MovingTime += duration
+		}
+
+		if speed > stats.MaxSpeed {
+			stats.MaxSpeed = speed
+		}
+	}
+
+	return stats
+}
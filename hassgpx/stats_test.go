@@ -0,0 +1,48 @@
+package hassgpx
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestHaversine(t *testing.T) {
+	moscow := Waypoint{Latitude: 55.7558, Longitude: 37.6173}
+	petersburg := Waypoint{Latitude: 59.9343, Longitude: 30.3351}
+	if distance := haversine(moscow, petersburg) / 1000; math.Abs(distance-634) > 2 {
+		t.Errorf("expected ~634 km, got %.1f km", distance)
+	}
+}
+
+func TestRideStats(t *testing.T) {
+	start := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	// 0.001° of latitude is ~111 m
+	ride := Ride{
+		{Latitude: 55.000, Longitude: 37, Elevation: float(100), Time: start},
+		{Latitude: 55.001, Longitude: 37, Elevation: float(105), Time: start.Add(20 * time.Second)},
+		{Latitude: 55.002, Longitude: 37, Elevation: float(103), Time: start.Add(40 * time.Second)},
+		{Latitude: 55.002, Longitude: 37, Elevation: float(103), Time: start.Add(100 * time.Second)},
+		{Latitude: 55.003, Longitude: 37, Elevation: float(110), Time: start.Add(110 * time.Second)},
+	}
+
+	stats := rideStats(ride)
+	if math.Abs(stats.Distance-0.3336) > 0.001 {
+		t.Errorf("expected ~0.334 km, got %.4f km", stats.Distance)
+	}
+
+	if stats.MovingTime != 50*time.Second {
+		t.Errorf("expected 50s moving time, got %s", stats.MovingTime)
+	}
+
+	if math.Abs(stats.MaxSpeed-40.03) > 0.1 {
+		t.Errorf("expected ~40 km/h max speed, got %.2f", stats.MaxSpeed)
+	}
+
+	if stats.ElevationGain != 12 {
+		t.Errorf("expected 12 m elevation gain, got %.1f", stats.ElevationGain)
+	}
+}
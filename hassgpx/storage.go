@@ -2,7 +2,7 @@ package hassgpx
 
 import (
 	"context"
-	_ "embed"
+	"embed"
 	"time"
 
 	"github.com/jfk9w-go/flu/apfel"
@@ -10,11 +10,19 @@ import (
 	"gorm.io/gorm"
 )
 
-//go:embed ddl/gps.sql
-var gpsDDL string
+//go:embed ddl
+var ddl embed.FS
 
+// StorageInterface provides tracking points ordered by time.
+type StorageInterface interface {
+	GetPoints(ctx context.Context, entityID string, since, until time.Time, maxAccuracy float64) ([]Waypoint, error)
+	GetZone(ctx context.Context, entityID string) (Zone, error)
+}
+
+// Storage collects tracking data from Home Assistant recorder database.
 type Storage[C Context] struct {
-	db *gorm.DB
+	db     *gorm.DB
+	schema recorderSchema
 }
 
 func (s Storage[C]) String() string {
@@ -29,24 +37,82 @@ func (s *Storage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 	}
 
 	db := gorm.DB()
-	if err := db.WithContext(ctx).Exec(gpsDDL).Error; err != nil {
-		return errors.Wrap(err, "create gps view")
+	schema, err := createViews(ctx, db, config.DB.Driver)
+	if err != nil {
+		return err
 	}
 
 	s.db = db
+	s.schema = schema
 	return nil
 }
 
-func (s *Storage[C]) GetLastTrack(ctx context.Context, entityID string, since time.Time, maxSpeed float64, moveInterval time.Duration) ([]Waypoint, error) {
-	rows := make([]Waypoint, 0)
-	return rows, s.db.WithContext(ctx).Raw( /* language=SQL */ `
-	select s1.time, s1.latitude, s1.longitude
-	from gps s1 left join gps s2 on s1.old_state_id = s2.state_id
-	where s1.entity_id like ?
-		and abs(extract(epoch from s1.time - s2.time)) < ?
-		and sqrt(pow(s1.latitude - s2.latitude, 2) + pow(s1.longitude - s2.longitude, 2)) / abs(extract(epoch from s1.time - s2.time)) * 111 * 3600 < ?
-		and s1.time >= ?
-	order by 1 asc`, entityID, moveInterval.Seconds(), maxSpeed, since).
+type gpsRow struct {
+	Time      recorderTime
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64
+	Speed     *float64
+	Course    *float64
+}
+
+func (r gpsRow) waypoint() Waypoint {
+	waypoint := Waypoint{
+		Latitude:  r.Latitude,
+		Longitude: r.Longitude,
+		Elevation: r.Altitude,
+		Time:      time.Time(r.Time),
+	}
+
+	if r.Speed != nil || r.Course != nil {
+		waypoint.Extensions = &WaypointExtensions{
+			TrackPoint: TrackPointExtension{
+				Speed:  r.Speed,
+				Course: r.Course,
+			},
+		}
+	}
+
+	return waypoint
+}
+
+func (s *Storage[C]) GetPoints(ctx context.Context, entityID string, since, until time.Time, maxAccuracy float64) ([]Waypoint, error) {
+	rows := make([]gpsRow, 0)
+	if err := s.db.WithContext(ctx).Raw( /* language=SQL */ `
+	select time, latitude, longitude, altitude, speed, course
+	from gps
+	where entity_id like ?
+		and time >= ?
+		and time < ?
+		and (? <= 0 or gps_accuracy is null or gps_accuracy <= ?)
+	order by 1 asc`, entityID, s.schema.timeArg(since), s.schema.timeArg(until), maxAccuracy, maxAccuracy).
 		Scan(&rows).
-		Error
+		Error; err != nil {
+		return nil, err
+	}
+
+	points := make([]Waypoint, len(rows))
+	for i, row := range rows {
+		points[i] = row.waypoint()
+	}
+
+	return points, nil
+}
+
+func (s *Storage[C]) GetZone(ctx context.Context, entityID string) (Zone, error) {
+	var zones []Zone
+	if err := s.db.WithContext(ctx).Raw( /* language=SQL */ `
+	select latitude, longitude, radius
+	from zones
+	where entity_id = ?`, entityID).
+		Scan(&zones).
+		Error; err != nil {
+		return Zone{}, err
+	}
+
+	if len(zones) == 0 {
+		return Zone{}, errors.Errorf("zone [%s] not found", entityID)
+	}
+
+	return zones[0], nil
 }
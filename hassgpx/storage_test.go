@@ -0,0 +1,190 @@
+package hassgpx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var testZoneAttributes = []string{
+	`{"latitude": 55.7, "longitude": 37.6, "radius": 100, "friendly_name": "Home"}`,
+	`{"latitude": 55.75, "longitude": 37.61, "radius": 150, "friendly_name": "Home"}`,
+}
+
+var testAttributes = []string{
+	`{"source_type": "gps", "latitude": 55.75, "longitude": 37.61, "gps_accuracy": 10, "altitude": 150.5, "speed": 4}`,
+	`{"source_type": "gps", "latitude": 55.76, "longitude": 37.62, "gps_accuracy": 100}`,
+	`{"source_type": "router"}`,
+	`{"source_type": "gps", "latitude": 55.77, "longitude": 37.63}`,
+	`{"source_type": "gps", "latitude": 55.78, "longitude": 37.64, "gps_accuracy": 5}`,
+}
+
+func openTestRecorder(t *testing.T, version int, ddl string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), new(gorm.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range []string{
+		"create table schema_changes (change_id integer primary key, schema_version integer, changed datetime)",
+		ddl,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, version := range []int{version - 1, version} {
+		if err := db.Exec("insert into schema_changes (schema_version) values (?)", version).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func checkTestPoints(t *testing.T, db *gorm.DB, start time.Time) {
+	ctx := context.Background()
+	var storage Storage[Context]
+	// the view should be recreated without errors
+	for i := 0; i < 2; i++ {
+		schema, err := createViews(ctx, db, "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		storage = Storage[Context]{db: db, schema: schema}
+	}
+
+	points, err := storage.GetPoints(ctx, "device_tracker.%", start, start.Add(4*time.Minute), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(points))
+	}
+
+	first := points[0]
+	if !first.Time.Equal(start) || first.Latitude != 55.75 || first.Longitude != 37.61 {
+		t.Errorf("unexpected first point: %+v", first)
+	}
+
+	if first.Elevation == nil || *first.Elevation != 150.5 {
+		t.Errorf("expected elevation 150.5, got %v", first.Elevation)
+	}
+
+	if first.Extensions == nil || *first.Extensions.TrackPoint.Speed != 4 {
+		t.Errorf("expected speed 4, got %+v", first.Extensions)
+	}
+
+	if !points[1].Time.Equal(start.Add(3*time.Minute)) || points[1].Latitude != 55.77 || points[1].Extensions != nil {
+		t.Errorf("unexpected second point: %+v", points[1])
+	}
+
+	zone, err := storage.GetZone(ctx, "zone.home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if zone != (Zone{Latitude: 55.75, Longitude: 37.61, Radius: 150}) {
+		t.Errorf("unexpected zone: %+v", zone)
+	}
+
+	if _, err := storage.GetZone(ctx, "zone.work"); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+}
+
+func TestStorage_SQLite(t *testing.T) {
+	db := openTestRecorder(t, 24, `
+	create table states (
+		state_id integer primary key,
+		entity_id varchar(255),
+		state varchar(255),
+		attributes text,
+		last_updated datetime,
+		created datetime,
+		old_state_id integer
+	)`)
+
+	start := time.Date(2022, 6, 15, 10, 0, 0, 0, time.UTC)
+	for i, attributes := range testAttributes {
+		if err := db.Exec("insert into states (entity_id, attributes, created) values (?, ?, ?)",
+			"device_tracker.phone", attributes, start.Add(time.Duration(i)*time.Minute).Format("2006-01-02 15:04:05.000000"),
+		).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, attributes := range testZoneAttributes {
+		if err := db.Exec("insert into states (entity_id, attributes, created) values (?, ?, ?)",
+			"zone.home", attributes, start.Format("2006-01-02 15:04:05.000000"),
+		).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkTestPoints(t, db, start)
+}
+
+func TestStorage_SQLiteStatesMeta(t *testing.T) {
+	db := openTestRecorder(t, 41, `
+	create table states (
+		state_id integer primary key,
+		entity_id char(0),
+		state varchar(255),
+		attributes char(0),
+		last_updated datetime,
+		last_updated_ts float,
+		old_state_id integer,
+		attributes_id integer,
+		metadata_id integer
+	)`)
+
+	for _, statement := range []string{
+		"create table state_attributes (attributes_id integer primary key, hash bigint, shared_attrs text)",
+		"create table states_meta (metadata_id integer primary key, entity_id varchar(255))",
+		"insert into states_meta (entity_id) values ('sensor.phone_battery'), ('device_tracker.phone'), ('zone.home')",
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Date(2022, 6, 15, 10, 0, 0, 0, time.UTC)
+	for i, attributes := range testAttributes {
+		if err := db.Exec("insert into state_attributes (shared_attrs) values (?)", attributes).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Exec("insert into states (attributes_id, metadata_id, last_updated_ts) values (?, ?, ?)",
+			i+1, 2, float64(start.Add(time.Duration(i)*time.Minute).Unix()),
+		).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, attributes := range testZoneAttributes {
+		if err := db.Exec("insert into state_attributes (shared_attrs) values (?)", attributes).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Exec("insert into states (attributes_id, metadata_id, last_updated_ts) values (?, ?, ?)",
+			len(testAttributes)+i+1, 3, float64(start.Unix()),
+		).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkTestPoints(t, db, start)
+}
+
+func TestCreateViews_UnknownDriver(t *testing.T) {
+	if _, err := createViews(context.Background(), nil, "oracle"); err == nil {
+		t.Error("expected error for unsupported driver")
+	}
+}
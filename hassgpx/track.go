@@ -0,0 +1,66 @@
+package hassgpx
+
+import (
+	"time"
+)
+
+// filterTrack keeps only the points which are "in track": they are preceded by another point within moveInterval,
+// and the speed smoothed over the sliding time window around them does not exceed maxSpeed (km/h).
+func filterTrack(points []Waypoint, maxSpeed float64, moveInterval, window time.Duration) []Waypoint {
+	track := make([]Waypoint, 0, len(points))
+	for start := 0; start < len(points); {
+		end := start + 1
+		for end < len(points) && points[end].Time.Sub(points[end-1].Time) < moveInterval {
+			end++
+		}
+
+		if end-start > 1 {
+			track = appendSlowPoints(track, points[start:end], maxSpeed, window)
+		}
+
+		start = end
+	}
+
+	return track
+}
+
+// appendSlowPoints appends points of a continuous run whose smoothed speed does not exceed maxSpeed.
+func appendSlowPoints(track []Waypoint, run []Waypoint, maxSpeed float64, window time.Duration) []Waypoint {
+	distances := make([]float64, len(run))
+	for i := 1; i < len(run); i++ {
+		distances[i] = distances[i-1] + haversine(run[i-1], run[i])
+	}
+
+	from, to := 0, 0
+	for _, point := range run {
+		for run[from].Time.Before(point.Time.Add(-window / 2)) {
+			from++
+		}
+
+		for to+1 < len(run) && !run[to+1].Time.After(point.Time.Add(window/2)) {
+			to++
+		}
+
+		lo, hi := from, to
+		if lo == hi {
+			// the window is too narrow, fall back to the pairwise speed
+			if hi < len(run)-1 {
+				hi++
+			} else {
+				lo--
+			}
+		}
+
+		duration := run[hi].Time.Sub(run[lo].Time)
+		if duration <= 0 {
+			continue
+		}
+
+		speed := (distances[hi] - distances[lo]) / 1000 / duration.Hours()
+		if speed <= maxSpeed {
+			track = append(track, point)
+		}
+	}
+
+	return track
+}
@@ -0,0 +1,113 @@
+package hassgpx
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const kmPerDegree = earthRadius * math.Pi / 180 / 1000
+
+type trackBuilder struct {
+	points []Waypoint
+	step   time.Duration
+}
+
+func newTrackBuilder(latitude, longitude float64) *trackBuilder {
+	return &trackBuilder{
+		points: []Waypoint{{
+			Latitude:  latitude,
+			Longitude: longitude,
+			Time:      time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+		}},
+		step: 10 * time.Second,
+	}
+}
+
+func (b *trackBuilder) last() Waypoint {
+	return b.points[len(b.points)-1]
+}
+
+// north moves north with the given speed (km/h) for n steps.
+func (b *trackBuilder) north(speed float64, n int) *trackBuilder {
+	for i := 0; i < n; i++ {
+		last := b.last()
+		last.Latitude += speed * b.step.Hours() / kmPerDegree
+		last.Time = last.Time.Add(b.step)
+		b.points = append(b.points, last)
+	}
+
+	return b
+}
+
+// east moves east with the given speed (km/h) for n steps.
+func (b *trackBuilder) east(speed float64, n int) *trackBuilder {
+	for i := 0; i < n; i++ {
+		last := b.last()
+		last.Longitude += speed * b.step.Hours() / kmPerDegree / math.Cos(last.Latitude*math.Pi/180)
+		last.Time = last.Time.Add(b.step)
+		b.points = append(b.points, last)
+	}
+
+	return b
+}
+
+func (b *trackBuilder) pause(duration time.Duration) *trackBuilder {
+	last := b.last()
+	last.Time = last.Time.Add(duration)
+	b.points = append(b.points, last)
+	return b
+}
+
+func TestFilterTrack_Train(t *testing.T) {
+	b := newTrackBuilder(55.75, 37.6).north(20, 30)
+	trainStart := b.last().Time
+	b.north(80, 30)
+	trainEnd := b.last().Time
+	b.north(20, 30)
+
+	track := filterTrack(b.points, 55, time.Minute, time.Minute)
+	for _, point := range track {
+		if point.Time.After(trainStart.Add(time.Minute)) && point.Time.Before(trainEnd.Add(-time.Minute)) {
+			t.Errorf("unexpected train point at %s", point.Time)
+		}
+	}
+
+	var bike int
+	for _, point := range track {
+		if !point.Time.After(trainStart) || !point.Time.Before(trainEnd) {
+			bike++
+		}
+	}
+
+	if bike != 62 {
+		t.Errorf("expected all 62 bike points to be in track, got %d", bike)
+	}
+}
+
+func TestFilterTrack_Latitude(t *testing.T) {
+	// 40 km/h eastwards would be estimated as 80 km/h by planar degree distance at 60°N.
+	points := newTrackBuilder(60, 30).east(40, 30).points
+	if track := filterTrack(points, 55, time.Minute, time.Minute); len(track) != len(points) {
+		t.Errorf("expected all %d points to be in track, got %d", len(points), len(track))
+	}
+}
+
+func TestFilterTrack_Smoothing(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.6).north(20, 10).north(60, 1).north(20, 10).points
+	if track := filterTrack(points, 55, time.Minute, time.Minute); len(track) != len(points) {
+		t.Errorf("expected single fast step to be smoothed out, got %d of %d points", len(track), len(points))
+	}
+
+	if track := filterTrack(points, 55, time.Minute, 10*time.Second); len(track) == len(points) {
+		t.Errorf("expected fast step to be filtered out with narrow window")
+	}
+}
+
+func TestFilterTrack_Gaps(t *testing.T) {
+	points := newTrackBuilder(55.75, 37.6).north(20, 5).pause(5*time.Minute).pause(5*time.Minute).north(20, 5).points
+	track := filterTrack(points, 55, time.Minute, time.Minute)
+	if len(track) != 12 {
+		t.Errorf("expected isolated point to be dropped, got %d of %d points", len(track), len(points))
+	}
+}
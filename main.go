@@ -17,7 +17,9 @@ import (
 	"github.com/jfk9w-go/telegram-bot-api"
 	tg "github.com/jfk9w-go/telegram-bot-api"
 	"github.com/jfk9w-go/telegram-bot-api/ext/tapp"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -32,7 +34,7 @@ type C struct {
 		Enabled        bool   `yaml:"enabled,omitempty" doc:"Enables the service and bot command."`
 		Encode         string `yaml:"encode,omitempty" enum:"gob,yml,json" doc:"This will generate encoded credentials data from current config which can be piped to a separate config file and then used as '--config.file' CLI argument.\nThis is done for illusion of safety: you can remove encoded credentials from plain text config, and technically this is safer, but you should also take other reasonable precautions.\nExample: './homebot --config.file=config.yml --tinkoff.encode=gob > credentials.gob; ./homebot --config.file=config.yml --config.file=credentials.gob'"`
 		tinkoff.Config `yaml:"-,inline"`
-	} `yaml:"tinkoff,omitempty" doc:"Tinkoff exposes an /update_bank_statement command which pulls data from tinkoff.ru API and puts it into a database for further use."`
+	} `yaml:"tinkoff,omitempty" doc:"Tinkoff exposes an /update_bank_statement command which pulls data from tinkoff.ru API and puts it into a database for further use.\nIt also exposes /spending, /export_statement and /receipts commands which summarize and export the pulled data."`
 }
 
 func (c C) TelegramConfig() tapp.Config   { return c.Telegram }
@@ -41,7 +43,7 @@ func (c C) HassGPXConfig() hassgpx.Config { return c.HassGPX.Config }
 func (c C) TinkoffConfig() tinkoff.Config { return c.Tinkoff.Config }
 
 const Description = `
-  homebot is a sort-of-everyday (?) tool collection in the form of Telegram bot. At the moment it supports three commands:
+  homebot is a sort-of-everyday (?) tool collection in the form of Telegram bot. At the moment it supports the following commands:
     
     /start                 – replies with your user ID and bot version
                              This can be used to get user ID in order to fill tinkoff.credentials configuration section.
@@ -50,8 +52,18 @@ const Description = `
                              See 'tinkoff' configuration section for more info.
                              Note that it's recommended to encode credentials so as not to keep them in plain text configuration.
 
-    /get_gpx_track         – collects Home Assistant tracking data from its database (only postgres supported)
-                             in GPX format.
+    /spending              – replies with spending summary by category and merchant (uses data pulled by /update_bank_statement)
+                             Accepts an optional period argument: week, month (default) or YYYY-MM.
+
+    /export_statement      – replies with CSV and XLSX statement including shopping receipt items
+                             Usage: /export_statement YYYY-MM-DD YYYY-MM-DD ["Account name (*123)"]
+
+    /receipts              – replies with shopping receipt goods analytics
+                             Usage: /receipts [top] [week|month|YYYY-MM], /receipts price <good ID> or /receipts jumps
+
+    /get_gpx_track         – collects Home Assistant tracking data from its database (postgres, sqlite or mysql) or REST API
+                             in GPX format. Accepts optional period (2022-06-01, 2022-06-01..2022-06-03 or -3d)
+                             activity (walk, run, bicycle or car by default) and format (gpx, kml, geojson, tcx or fit) arguments.
                              This uses some bold assumptions and rough approximations, you may want to check the code.
                              Also see 'hassgpx' configuration section for more info.
 `
@@ -88,6 +100,8 @@ func main() {
 		gorm = apfel.Gorm[C]{
 			Drivers: apfel.GormDrivers{
 				"postgres": postgres.Open,
+				"sqlite":   sqlite.Open,
+				"mysql":    mysql.Open,
 			},
 			Config: gorm.Config{
 				Logger: gormf.LogfLogger(app, func() logf.Interface { return logf.Get("gorm.sql") }),
@@ -3,14 +3,13 @@ package tinkoff
 import (
 	"context"
 	"fmt"
-	"sync/atomic"
+	"strings"
 	"time"
 
 	"homebot/3rdparty/tinkoff"
+	"homebot/common"
 
-	"gorm.io/gorm"
-
-	"github.com/jfk9w-go/flu/gormf"
+	"github.com/jfk9w-go/flu/colf"
 	"github.com/jfk9w-go/flu/syncf"
 	"github.com/pkg/errors"
 )
@@ -19,8 +18,12 @@ type canvas struct {
 	Client
 	StorageInterface
 	logger
+	clock    syncf.Clock
 	overlap  time.Duration
 	username string
+	budgets  []Budget
+	watch    WatchRules
+	notify   func(ctx context.Context, text string) error
 }
 
 type chapter interface {
@@ -48,17 +51,21 @@ func (accountsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error)
 
 	cvs.infof(ctx, "%d accounts updated", len(accounts))
 
-	chapters := make([]chapter, 2*len(accounts))
-	suspended := new(atomic.Value)
-	for i, account := range accounts {
-		chapters[i] = operationsChapter{
+	chapters := make([]chapter, 0, 2*len(accounts)+1)
+	for _, account := range accounts {
+		chapters = append(chapters, operationsChapter{
 			account: account,
-		}
+		})
+	}
 
-		chapters[len(accounts)+i] = shoppingReceiptsChapter{
-			account:   account,
-			suspended: suspended,
-		}
+	if len(cvs.budgets) > 0 {
+		chapters = append(chapters, budgetsChapter{})
+	}
+
+	for _, account := range accounts {
+		chapters = append(chapters, shoppingReceiptsChapter{
+			account: account,
+		})
 	}
 
 	return chapters, nil
@@ -91,60 +98,210 @@ func (c operationsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, er
 		return nil, errors.Wrap(err, "retrieve operations")
 	}
 
-	if err := cvs.RefreshOperations(ctx, c.account.ID, refreshStart, operations); err != nil {
+	newIDs, err := cvs.RefreshOperations(ctx, c.account.ID, refreshStart, operations)
+	if err != nil {
 		return nil, errors.Wrapf(err, "refresh %d operations in db", len(operations))
 	}
 
 	cvs.infof(ctx, "%d operations updated since %s", len(operations), refreshStart)
 
+	// the whole history is loaded on initial sync, so it is not announced
+	if !refreshStart.IsZero() && cvs.watch.enabled() {
+		c.announce(ctx, cvs, operations, newIDs)
+	}
+
 	return nil, nil
 }
 
-type shoppingReceiptsChapter struct {
-	account   tinkoff.Account
-	suspended *atomic.Value
-}
+// announce adds pending announcements for new operations matching watch rules
+// and sends all pending announcements of the account.
+// An announcement stays pending until it is sent, so failed ones are retried on the next sync
+// even if the operation is not refreshed anymore.
+func (c operationsChapter) announce(ctx context.Context, cvs *canvas, operations []tinkoff.Operation, newIDs []uint64) {
+	isNew := make(map[uint64]bool, len(newIDs))
+	for _, id := range newIDs {
+		isNew[id] = true
+	}
 
-func (c shoppingReceiptsChapter) name() string {
-	return fmt.Sprintf("💳 %s", c.account)
-}
+	for _, operation := range operations {
+		if !isNew[operation.ID] || operation.Type != "Debit" || operation.Status == "FAILED" {
+			continue
+		}
 
-func (c shoppingReceiptsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
-	if suspended, _ := c.suspended.Load().(bool); suspended {
-		return nil, errors.New("receipt sync is suspended now, try again later")
+		reasons, err := c.watch(ctx, cvs, operation)
+		if err != nil {
+			cvs.warnf(ctx, "check operation %d: %v", operation.ID, err)
+			continue
+		}
+
+		if len(reasons) == 0 {
+			continue
+		}
+
+		merchant := operation.Merchant.Name.ValueOrZero()
+		if merchant == "" {
+			merchant = operation.Description
+		}
+
+		// the text is stored so that watch rules are checked only once per operation
+		announcement := OperationAnnouncement{
+			OperationID: operation.ID,
+			AccountID:   c.account.ID,
+			Text: fmt.Sprintf("🚨 %.2f %s – %s\n%s, %s\n%s",
+				operation.Amount.Value, operation.Amount.Currency.Name, merchant,
+				c.account, time.Time(operation.Time).In(tinkoff.MoscowLocation).Format("02.01.2006 15:04"),
+				strings.Join(reasons, ", ")),
+		}
+
+		if _, err := cvs.AddOperationAnnouncement(ctx, announcement); err != nil {
+			cvs.warnf(ctx, "add operation %d announcement: %v", operation.ID, err)
+		}
 	}
 
-	pendingReceiptIDs, err := cvs.GetPendingShoppingReceiptOperationIDs(ctx, c.account.ID)
+	pending, err := cvs.GetPendingOperationAnnouncements(ctx, c.account.ID)
 	if err != nil {
-		return nil, errors.Wrap(err, "get pending shopping receipt operation ids")
+		cvs.warnf(ctx, "get pending announcements: %v", err)
+		return
 	}
 
-	for i, operationID := range pendingReceiptIDs {
-		receipt, err := cvs.GetShoppingReceipt(ctx, tinkoff.OperationReceipt{
-			OperationID: operationID,
-		})
+	announced := 0
+	for _, announcement := range pending {
+		if err := cvs.notify(ctx, announcement.Text); err != nil {
+			cvs.warnf(ctx, "announce operation %d: %v", announcement.OperationID, err)
+			continue
+		}
+
+		if err := cvs.CompleteOperationAnnouncement(ctx, announcement.OperationID); err != nil {
+			cvs.warnf(ctx, "complete operation %d announcement: %v", announcement.OperationID, err)
+		}
+
+		announced++
+	}
+
+	if announced > 0 {
+		cvs.infof(ctx, "%d operations announced", announced)
+	}
+}
+
+func (c operationsChapter) watch(ctx context.Context, cvs *canvas, operation tinkoff.Operation) ([]string, error) {
+	var reasons []string
+	if cvs.watch.Amount > 0 && operation.AccountAmount.Value >= cvs.watch.Amount {
+		reasons = append(reasons, fmt.Sprintf("amount over %.2f", cvs.watch.Amount))
+	}
 
-		switch {
-		case errors.Is(err, tinkoff.ErrRequestRateLimitExceeded) || syncf.IsContextRelated(err):
-			c.suspended.Store(true)
-			return nil, errors.Wrapf(err, "retrieve receipt %d/%d", i+1, len(pendingReceiptIDs))
-		case errors.Is(err, tinkoff.ErrNoDataFound):
-			if err := cvs.RemoveShoppingReceiptFlag(ctx, operationID); err != nil {
-				cvs.warnf(ctx, "remove receipt flag %d/%d: %v", i+1, len(pendingReceiptIDs), err)
+	if country := operation.Merchant.Region.Country; cvs.watch.HomeCountry != "" &&
+		country.Valid && country.String != cvs.watch.HomeCountry {
+		reasons = append(reasons, "foreign merchant ("+country.String+")")
+	}
+
+	if cvs.watch.CardNotPresent && !operation.CardPresent {
+		reasons = append(reasons, "card not present")
+	}
+
+	if cvs.watch.NewMerchant && operation.Merchant.Name.ValueOrZero() != "" {
+		first, err := cvs.IsFirstMerchantOperation(ctx, cvs.username, operation)
+		if err != nil {
+			return nil, errors.Wrap(err, "check merchant")
+		}
+
+		if first {
+			reasons = append(reasons, "new merchant")
+		}
+	}
+
+	return reasons, nil
+}
+
+var budgetThresholds = []int{80, 100}
+
+type budgetsChapter struct{}
+
+func (budgetsChapter) name() string {
+	return "🎯 Budgets"
+}
+
+func (budgetsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
+	now := cvs.clock.Now().In(tinkoff.MoscowLocation)
+	month := monthPeriod(now.Year(), now.Month(), now.Location())
+	for _, budget := range cvs.budgets {
+		spent, err := cvs.GetBudgetSpending(ctx, cvs.username, budget, month.since, month.until)
+		if err != nil {
+			cvs.warnf(ctx, "get %s spending: %v", budget, err)
+			continue
+		}
+
+		var alerts []BudgetAlert
+		for _, threshold := range budgetThresholds {
+			if spent < budget.Limit*float64(threshold)/100 {
+				break
+			}
+
+			alert := BudgetAlert{
+				Username:  cvs.username,
+				Budget:    budget.String(),
+				Period:    month.since,
+				Threshold: threshold,
+			}
+
+			exists, err := cvs.HasBudgetAlert(ctx, alert)
+			if err != nil {
+				cvs.warnf(ctx, "check %s alert for %d%%: %v", budget, threshold, err)
+				break
+			}
+
+			if !exists {
+				alerts = append(alerts, alert)
 			}
+		}
+
+		if len(alerts) == 0 {
+			continue
+		}
 
-			fallthrough
-		case err != nil:
+		reached := alerts[len(alerts)-1].Threshold
+		text := fmt.Sprintf("🎯 %s: %.2f of %.2f ₽ spent (%.0f%%) in %s",
+			budget, spent, budget.Limit, spent/budget.Limit*100, now.Format("01.2006"))
+		if err := cvs.notify(ctx, text); err != nil {
+			cvs.warnf(ctx, "send %s alert for %d%%: %v", budget, reached, err)
 			continue
 		}
 
-		if err := cvs.StoreShoppingReceipt(ctx, receipt); err != nil {
-			cvs.warnf(ctx, "store receipt %d/%d: %v", i+1, len(pendingReceiptIDs), err)
+		// alerts are recorded only after they are sent so that failed ones are retried on the next sync
+		for _, alert := range alerts {
+			if _, err := cvs.AddBudgetAlert(ctx, alert); err != nil {
+				cvs.warnf(ctx, "add %s alert for %d%%: %v", budget, alert.Threshold, err)
+			}
 		}
+
+		cvs.infof(ctx, "%s alert for %d%% sent", budget, reached)
+	}
+
+	return nil, nil
+}
+
+// shoppingReceiptsChapter queues pending shopping receipts of the account.
+// They are fetched later by receiptQueue.
+type shoppingReceiptsChapter struct {
+	account tinkoff.Account
+}
+
+func (c shoppingReceiptsChapter) name() string {
+	return fmt.Sprintf("💳 %s", c.account)
+}
+
+func (c shoppingReceiptsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
+	pendingReceiptIDs, err := cvs.GetPendingShoppingReceiptOperationIDs(ctx, c.account.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pending shopping receipt operation ids")
+	}
+
+	queued, err := cvs.QueueShoppingReceipts(ctx, cvs.username, pendingReceiptIDs, cvs.clock.Now())
+	if err != nil {
+		return nil, errors.Wrapf(err, "queue %d receipts", len(pendingReceiptIDs))
 	}
 
-	if len(pendingReceiptIDs) > 0 {
-		cvs.infof(ctx, "%d receipts updated", len(pendingReceiptIDs))
+	if queued > 0 {
+		cvs.infof(ctx, "%d receipts queued", queued)
 	}
 
 	return nil, nil
@@ -171,15 +328,7 @@ func (tradingOperationsChapter) sync(ctx context.Context, cvs *canvas) ([]chapte
 		return nil, errors.Wrap(err, "retrieve")
 	}
 
-	if err := cvs.Tx(ctx, func(tx *gorm.DB) error {
-		tx, err := gormf.Filter(tx, items, "tenant", "=", cvs.username)
-		if err != nil {
-			return err
-		}
-
-		batch := gormf.Batch[tinkoff.TradingOperation](items)
-		return batch.Ensure(tx, "primaryKey")
-	}); err != nil {
+	if err := cvs.StoreTradingOperations(ctx, cvs.username, items); err != nil {
 		return nil, errors.Wrapf(err, "update %d operations in db", len(items))
 	}
 
@@ -188,27 +337,64 @@ func (tradingOperationsChapter) sync(ctx context.Context, cvs *canvas) ([]chapte
 	}
 
 	return []chapter{
-		purchasedSecuritiesChapter{},
+		brokerAccountsChapter{},
 		candlesChapter{},
 	}, nil
 }
 
-type purchasedSecuritiesChapter struct{}
+var defaultPortfolioCurrency = "RUB"
+
+type brokerAccountsChapter struct{}
 
-func (purchasedSecuritiesChapter) name() string {
-	return "🔐 Purchased securities"
+func (brokerAccountsChapter) name() string {
+	return "💼 Broker accounts"
 }
 
-func (purchasedSecuritiesChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
-	items, err := cvs.GetPurchasedSecurities(ctx, tinkoff.TinkoffRUB)
+func (brokerAccountsChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
+	accounts, err := cvs.GetBrokerAccounts(ctx, tinkoff.BrokerAccounts{})
 	if err != nil {
 		return nil, errors.Wrap(err, "retrieve")
 	}
 
-	if err := cvs.Tx(ctx, func(tx *gorm.DB) error {
-		batch := gormf.Batch[tinkoff.PurchasedSecurity](items)
-		return batch.Ensure(cvs.DB(ctx), "primaryKey")
-	}); err != nil {
+	currencies, err := cvs.GetTradingCurrencies(ctx, cvs.username)
+	if err != nil {
+		return nil, errors.Wrap(err, "get trading currencies")
+	}
+
+	if !colf.Contains[string](colf.Slice[string](currencies), defaultPortfolioCurrency) {
+		currencies = append(currencies, defaultPortfolioCurrency)
+	}
+
+	chapters := make([]chapter, 0, len(accounts)*len(currencies))
+	for _, account := range accounts {
+		for _, currency := range currencies {
+			chapters = append(chapters, purchasedSecuritiesChapter{
+				request: tinkoff.PurchasedSecurities{
+					BrokerAccountType: account.Type,
+					Currency:          currency,
+				},
+			})
+		}
+	}
+
+	return chapters, nil
+}
+
+type purchasedSecuritiesChapter struct {
+	request tinkoff.PurchasedSecurities
+}
+
+func (c purchasedSecuritiesChapter) name() string {
+	return fmt.Sprintf("🔐 %s (%s)", c.request.BrokerAccountType, c.request.Currency)
+}
+
+func (c purchasedSecuritiesChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
+	items, err := cvs.GetPurchasedSecurities(ctx, c.request)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieve")
+	}
+
+	if err := cvs.StorePurchasedSecurities(ctx, cvs.username, items); err != nil {
 		return nil, errors.Wrapf(err, "store %d items in db", len(items))
 	}
 
@@ -225,6 +411,89 @@ func (candlesChapter) name() string {
 	return "🕯 Candles"
 }
 
+type candlesWindow struct {
+	from, to time.Time
+}
+
 func (candlesChapter) sync(ctx context.Context, cvs *canvas) ([]chapter, error) {
+	latestDates, err := cvs.GetLatestCandleDates(ctx, cvs.username)
+	if err != nil {
+		return nil, errors.Wrap(err, "get latest candle dates")
+	}
+
+	// windows are trimmed by the latest candle date of each ticker below,
+	// so closed positions are not filtered out here
+	positions, err := cvs.GetTradingPositions(ctx, cvs.username)
+	if err != nil {
+		return nil, errors.Wrap(err, "get trading positions")
+	}
+
+	now := cvs.clock.Now()
+	windows := make(map[string]*candlesWindow)
+	for _, position := range positions {
+		from, to := tinkoff.TradingTimeStart, now
+		if position.BuyTime != nil {
+			from = common.TrimDate(*position.BuyTime)
+		}
+
+		if position.SellTime != nil {
+			to = common.TrimDate(*position.SellTime).Add(24 * time.Hour)
+		}
+
+		window, ok := windows[position.Ticker]
+		if !ok {
+			windows[position.Ticker] = &candlesWindow{from: from, to: to}
+			continue
+		}
+
+		if from.Before(window.from) {
+			window.from = from
+		}
+
+		if to.After(window.to) {
+			window.to = to
+		}
+	}
+
+	updated := 0
+	for ticker, window := range windows {
+		if latestDate, ok := latestDates[ticker]; ok {
+			if latestDate = latestDate.Add(-cvs.overlap); latestDate.After(window.from) {
+				window.from = common.TrimDate(latestDate)
+			}
+		}
+
+		if !window.from.Before(window.to) {
+			continue
+		}
+
+		candles, err := cvs.GetCandles(ctx, tinkoff.Candles{
+			Ticker:     ticker,
+			Resolution: "D",
+			From:       window.from,
+			To:         window.to,
+		})
+
+		if err != nil {
+			cvs.warnf(ctx, "retrieve %s candles [%s, %s]: %v", ticker, window.from, window.to, err)
+			continue
+		}
+
+		if len(candles) == 0 {
+			continue
+		}
+
+		if err := cvs.StoreCandles(ctx, cvs.username, candles); err != nil {
+			cvs.warnf(ctx, "store %d %s candles in db: %v", len(candles), ticker, err)
+			continue
+		}
+
+		updated += len(candles)
+	}
+
+	if updated > 0 {
+		cvs.infof(ctx, "%d candles updated for %d tickers", updated, len(windows))
+	}
+
 	return nil, nil
 }
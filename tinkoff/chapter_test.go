@@ -0,0 +1,1025 @@
+package tinkoff
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+	"homebot/3rdparty/tinkoff/tinkofftest"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/flu/apfel"
+	"github.com/jfk9w-go/flu/colf"
+	"github.com/jfk9w-go/flu/logf"
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+type testConfig struct{}
+
+type memoryStorage struct {
+	accounts            map[string]tinkoff.Account
+	operations          map[uint64]tinkoff.Operation
+	receipts            map[uint64]*tinkoff.ShoppingReceipt
+	tradingOperations   map[uint64]tinkoff.TradingOperation
+	purchasedSecurities []tinkoff.PurchasedSecurity
+	candles             map[string]map[time.Time]tinkoff.Candle
+	positions           []TradingPosition
+	budgetAlerts        map[BudgetAlert]bool
+	announcements       map[uint64]OperationAnnouncement
+	queue               map[uint64]QueuedShoppingReceipt
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		accounts:          make(map[string]tinkoff.Account),
+		operations:        make(map[uint64]tinkoff.Operation),
+		receipts:          make(map[uint64]*tinkoff.ShoppingReceipt),
+		tradingOperations: make(map[uint64]tinkoff.TradingOperation),
+		candles:           make(map[string]map[time.Time]tinkoff.Candle),
+		budgetAlerts:      make(map[BudgetAlert]bool),
+		announcements:     make(map[uint64]OperationAnnouncement),
+		queue:             make(map[uint64]QueuedShoppingReceipt),
+	}
+}
+
+func (s *memoryStorage) RefreshAccounts(_ context.Context, username string, accounts []tinkoff.Account) error {
+	ids := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		s.accounts[account.ID] = account
+		ids[account.ID] = true
+	}
+
+	for id, account := range s.accounts {
+		if account.Username == username && !ids[id] {
+			account.Archived = true
+			s.accounts[id] = account
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) GetOperationRefreshIntervalStart(_ context.Context, accountID string) (time.Time, error) {
+	var minPending, latest time.Time
+	for _, operation := range s.operations {
+		if operation.AccountID != accountID {
+			continue
+		}
+
+		opTime := time.Time(operation.Time)
+		if operation.DebitingTime == nil && (minPending.IsZero() || opTime.Before(minPending)) {
+			minPending = opTime
+		}
+
+		if opTime.After(latest) {
+			latest = opTime
+		}
+	}
+
+	if !minPending.IsZero() {
+		return minPending, nil
+	}
+
+	return latest, nil
+}
+
+func (s *memoryStorage) RefreshOperations(_ context.Context, accountID string, since time.Time, operations []tinkoff.Operation) ([]uint64, error) {
+	var newIDs []uint64
+	for _, operation := range operations {
+		if _, ok := s.operations[operation.ID]; !ok {
+			newIDs = append(newIDs, operation.ID)
+		}
+	}
+
+	for id, operation := range s.operations {
+		if operation.AccountID == accountID && operation.DebitingTime == nil && !time.Time(operation.Time).Before(since) {
+			delete(s.operations, id)
+		}
+	}
+
+	for _, operation := range operations {
+		s.operations[operation.ID] = operation
+	}
+
+	return newIDs, nil
+}
+
+func (s *memoryStorage) GetPendingShoppingReceiptOperationIDs(_ context.Context, accountID string) ([]uint64, error) {
+	var ids []uint64
+	for id, operation := range s.operations {
+		if operation.AccountID == accountID && operation.HasShoppingReceipt && operation.DebitingTime != nil && s.receipts[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (s *memoryStorage) StoreShoppingReceipt(_ context.Context, receipt *tinkoff.ShoppingReceipt) error {
+	s.receipts[receipt.OperationID] = receipt
+	return nil
+}
+
+func (s *memoryStorage) RemoveShoppingReceiptFlag(_ context.Context, operationID uint64) error {
+	operation := s.operations[operationID]
+	operation.HasShoppingReceipt = false
+	s.operations[operationID] = operation
+	return nil
+}
+
+func (s *memoryStorage) QueueShoppingReceipts(_ context.Context, username string, operationIDs []uint64, at time.Time) (int, error) {
+	for id, receipt := range s.queue {
+		if _, ok := s.operations[id]; !ok && receipt.Username == username {
+			delete(s.queue, id)
+		}
+	}
+
+	var queued int
+	for _, id := range operationIDs {
+		if _, ok := s.queue[id]; ok {
+			continue
+		}
+
+		s.queue[id] = QueuedShoppingReceipt{OperationID: id, Username: username, NextAttempt: at}
+		queued++
+	}
+
+	return queued, nil
+}
+
+func (s *memoryStorage) GetQueuedShoppingReceipts(_ context.Context, username string, until time.Time) ([]QueuedShoppingReceipt, error) {
+	var receipts []QueuedShoppingReceipt
+	for _, receipt := range s.queue {
+		if receipt.Username == username && !receipt.NextAttempt.After(until) {
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	sort.Slice(receipts, func(i, j int) bool {
+		if !receipts[i].NextAttempt.Equal(receipts[j].NextAttempt) {
+			return receipts[i].NextAttempt.Before(receipts[j].NextAttempt)
+		}
+
+		return receipts[i].OperationID < receipts[j].OperationID
+	})
+
+	return receipts, nil
+}
+
+func (s *memoryStorage) UpdateQueuedShoppingReceipt(_ context.Context, receipt QueuedShoppingReceipt) error {
+	s.queue[receipt.OperationID] = receipt
+	return nil
+}
+
+func (s *memoryStorage) RemoveQueuedShoppingReceipt(_ context.Context, operationID uint64) error {
+	delete(s.queue, operationID)
+	return nil
+}
+
+func (s *memoryStorage) GetLatestTime(_ context.Context, entity interface{}, tenant interface{}) (latestTime time.Time, err error) {
+	switch entity.(type) {
+	case *tinkoff.TradingOperation:
+		for _, operation := range s.tradingOperations {
+			if operation.Username == tenant && time.Time(operation.Time).After(latestTime) {
+				latestTime = time.Time(operation.Time)
+			}
+		}
+
+		return
+	default:
+		return latestTime, errors.Errorf("unsupported entity %T", entity)
+	}
+}
+
+func (s *memoryStorage) GetTradingPositions(_ context.Context, _ string) ([]TradingPosition, error) {
+	return s.positions, nil
+}
+
+func (s *memoryStorage) GetTradingCurrencies(_ context.Context, username string) ([]string, error) {
+	currencies := make(colf.Set[string])
+	for _, operation := range s.tradingOperations {
+		if operation.Username == username {
+			currencies.Add(operation.Currency)
+		}
+	}
+
+	return colf.ToSlice[string](currencies), nil
+}
+
+func (s *memoryStorage) GetLatestCandleDates(_ context.Context, username string) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	for ticker, candles := range s.candles {
+		for date, candle := range candles {
+			if candle.Username == username && date.After(dates[ticker]) {
+				dates[ticker] = date
+			}
+		}
+	}
+
+	return dates, nil
+}
+
+func (s *memoryStorage) StoreTradingOperations(_ context.Context, username string, items []tinkoff.TradingOperation) error {
+	for _, item := range items {
+		item.Username = username
+		s.tradingOperations[item.ID] = item
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) StorePurchasedSecurities(_ context.Context, username string, items []tinkoff.PurchasedSecurity) error {
+	for _, item := range items {
+		item.Username = username
+		s.purchasedSecurities = append(s.purchasedSecurities, item)
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) StoreCandles(_ context.Context, username string, candles []tinkoff.Candle) error {
+	for _, candle := range candles {
+		candle.Username = username
+		if _, ok := s.candles[candle.Ticker]; !ok {
+			s.candles[candle.Ticker] = make(map[time.Time]tinkoff.Candle)
+		}
+
+		s.candles[candle.Ticker][time.Time(candle.Date)] = candle
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) GetBudgetSpending(_ context.Context, username string, budget Budget, since, until time.Time) (float64, error) {
+	var amount float64
+	for _, operation := range s.operations {
+		opTime := time.Time(operation.Time)
+		if s.accounts[operation.AccountID].Username != username || operation.Type != "Debit" ||
+			opTime.Before(since) || !opTime.Before(until) ||
+			budget.Category != "" && operation.SpendingCategory.Name != budget.Category ||
+			budget.MCC != "" && operation.MCC != budget.MCC {
+			continue
+		}
+
+		amount += operation.AccountAmount.Value
+	}
+
+	return amount, nil
+}
+
+func (s *memoryStorage) HasBudgetAlert(_ context.Context, alert BudgetAlert) (bool, error) {
+	return s.budgetAlerts[alert], nil
+}
+
+func (s *memoryStorage) AddBudgetAlert(_ context.Context, alert BudgetAlert) (bool, error) {
+	if s.budgetAlerts[alert] {
+		return false, nil
+	}
+
+	s.budgetAlerts[alert] = true
+	return true, nil
+}
+
+func (s *memoryStorage) IsFirstMerchantOperation(_ context.Context, username string, operation tinkoff.Operation) (bool, error) {
+	for _, other := range s.operations {
+		if s.accounts[other.AccountID].Username == username && other.ID != operation.ID &&
+			other.Merchant.Name == operation.Merchant.Name &&
+			time.Time(other.Time).Before(time.Time(operation.Time)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *memoryStorage) AddOperationAnnouncement(_ context.Context, announcement OperationAnnouncement) (bool, error) {
+	if _, ok := s.announcements[announcement.OperationID]; ok {
+		return false, nil
+	}
+
+	announcement.Pending = true
+	s.announcements[announcement.OperationID] = announcement
+	return true, nil
+}
+
+func (s *memoryStorage) GetPendingOperationAnnouncements(_ context.Context, accountID string) ([]OperationAnnouncement, error) {
+	var announcements []OperationAnnouncement
+	for _, announcement := range s.announcements {
+		if announcement.Pending && announcement.AccountID == accountID {
+			announcements = append(announcements, announcement)
+		}
+	}
+
+	sort.Slice(announcements, func(i, j int) bool { return announcements[i].OperationID < announcements[j].OperationID })
+	return announcements, nil
+}
+
+func (s *memoryStorage) CompleteOperationAnnouncement(_ context.Context, operationID uint64) error {
+	announcement := s.announcements[operationID]
+	announcement.Pending = false
+	s.announcements[operationID] = announcement
+	return nil
+}
+
+type testLog struct {
+	level   logf.Level
+	chapter string
+	msg     string
+}
+
+type testLogger struct {
+	name string
+	logs *[]testLog
+}
+
+func (l testLogger) printf(level logf.Level, msg string, args ...any) {
+	*l.logs = append(*l.logs, testLog{
+		level:   level,
+		chapter: l.name,
+		msg:     fmt.Sprintf(msg, args...),
+	})
+}
+
+func (l testLogger) infof(_ context.Context, msg string, args ...any) {
+	l.printf(logf.Info, msg, args...)
+}
+
+func (l testLogger) warnf(_ context.Context, msg string, args ...any) {
+	l.printf(logf.Warn, msg, args...)
+}
+
+func (l testLogger) errorf(_ context.Context, msg string, args ...any) {
+	l.printf(logf.Error, msg, args...)
+}
+
+func (l testLogger) sub(name string) logger {
+	return testLogger{name: name, logs: l.logs}
+}
+
+func (l testLogger) reset() {
+	*l.logs = nil
+}
+
+func (l testLogger) problems() []testLog {
+	var problems []testLog
+	for _, log := range *l.logs {
+		if log.level != logf.Info {
+			problems = append(problems, log)
+		}
+	}
+
+	return problems
+}
+
+func newTestCanvas(t *testing.T, server *tinkofftest.Server, storage StorageInterface) (canvas, testLogger) {
+	host := tinkoff.Host
+	tinkoff.Host = server.URL
+	t.Cleanup(func() { tinkoff.Host = host })
+
+	ctx := context.Background()
+	app := apfel.Boot[testConfig]{
+		Name:   "test",
+		Source: apfel.Environ(nil, "test"),
+	}.App(ctx)
+	t.Cleanup(func() { flu.CloseQuietly(app) })
+
+	if err := app.Use(ctx, &apfel.MixinAny[testConfig, ConfirmFunc]{
+		Value: func(ctx context.Context, username string) (string, error) {
+			return server.Code, nil
+		},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &tinkoff.Client[testConfig]{
+		Credential: Credential{
+			Username: "test",
+			Phone:    server.Phone,
+			Password: server.Password,
+		},
+	}
+
+	if err := app.Use(ctx, client, false); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := testLogger{logs: new([]testLog)}
+	return canvas{
+		Client:           client,
+		StorageInterface: storage,
+		logger:           logger,
+		clock:            syncf.DefaultClock,
+		overlap:          24 * time.Hour,
+		username:         "test",
+	}, logger
+}
+
+func testOperation(id uint64, accountID string, at time.Time, debited, receipt bool) map[string]any {
+	operation := map[string]any{
+		"id":                 fmt.Sprint(id),
+		"operationTime":      map[string]any{"milliseconds": at.UnixMilli()},
+		"type":               "Debit",
+		"group":              "PAY",
+		"status":             "OK",
+		"description":        "Shop",
+		"amount":             map[string]any{"currency": map[string]any{"name": "RUB"}, "value": 100.0},
+		"accountAmount":      map[string]any{"currency": map[string]any{"name": "RUB"}, "value": 100.0},
+		"spendingCategory":   map[string]any{"name": "Супермаркеты"},
+		"mccString":          "5411",
+		"cardPresent":        true,
+		"account":            accountID,
+		"hasShoppingReceipt": receipt,
+	}
+
+	if debited {
+		operation["debitingTime"] = map[string]any{"milliseconds": at.Add(24 * time.Hour).UnixMilli()}
+	}
+
+	return operation
+}
+
+func TestChapters(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+	}
+
+	server.Operations["5001"] = []any{
+		testOperation(1, "5001", now.Add(-72*time.Hour), true, true),
+		testOperation(2, "5001", now.Add(-time.Hour), false, false),
+	}
+
+	server.ShoppingReceipts[1] = map[string]any{
+		"receipt": map[string]any{
+			"totalSum": 100.0,
+			"items": []any{
+				map[string]any{"name": "Bread", "price": 100.0, "sum": 100.0, "quantity": 1.0},
+			},
+		},
+	}
+
+	buyTime := now.Add(-72 * time.Hour)
+	server.TradingOperations = []any{
+		map[string]any{
+			"id":             1,
+			"date":           buyTime.In(tinkoff.MoscowLocation).Format("2006-01-02T15:04:05-07:00"),
+			"operationType":  "Buy",
+			"instrumentType": "Stock",
+			"ticker":         "TCSG",
+			"price":          1000.0,
+			"payment":        -1000.0,
+			"currency":       "RUB",
+			"quantity":       1,
+			"description":    "Buy",
+		},
+		map[string]any{
+			"id":             2,
+			"date":           buyTime.In(tinkoff.MoscowLocation).Format("2006-01-02T15:04:05-07:00"),
+			"operationType":  "PayIn",
+			"instrumentType": "Currency",
+			"payment":        100.0,
+			"currency":       "USD",
+			"description":    "Pay in",
+		},
+	}
+
+	server.BrokerAccounts = []any{
+		map[string]any{"brokerAccountType": "Tinkoff"},
+		map[string]any{"brokerAccountType": "TinkoffIis"},
+	}
+
+	server.PurchasedSecurities["Tinkoff/RUB"] = []any{
+		map[string]any{
+			"ticker":       "TCSG",
+			"securityType": "Stock",
+			"currentPrice": map[string]any{"currency": "RUB", "value": 1100.0},
+		},
+	}
+
+	server.PurchasedSecurities["TinkoffIis/USD"] = []any{
+		map[string]any{
+			"ticker":       "AAPL",
+			"securityType": "Stock",
+			"currentPrice": map[string]any{"currency": "USD", "value": 150.0},
+		},
+	}
+
+	server.Candles["TCSG"] = []any{
+		map[string]any{"date": buyTime.Unix(), "o": 1000.0, "c": 1050.0, "h": 1060.0, "l": 990.0, "v": 100.0},
+		map[string]any{"date": buyTime.Add(24 * time.Hour).Unix(), "o": 1050.0, "c": 1100.0, "h": 1110.0, "l": 1040.0, "v": 100.0},
+	}
+
+	storage := newMemoryStorage()
+	storage.positions = []TradingPosition{{Ticker: "TCSG", BuyTime: &buyTime}}
+
+	cvs, logger := newTestCanvas(t, server, storage)
+	run(context.Background(), cvs)
+
+	if problems := logger.problems(); len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	if len(storage.accounts) != 1 || storage.accounts["5001"].Username != "test" {
+		t.Errorf("unexpected accounts: %+v", storage.accounts)
+	}
+
+	if len(storage.operations) != 2 {
+		t.Errorf("expected 2 operations, got %d", len(storage.operations))
+	}
+
+	queue := receiptQueue{
+		Client:           cvs.Client,
+		StorageInterface: storage,
+		clock:            cvs.clock,
+		username:         "test",
+		backoff:          time.Minute,
+		maxBackoff:       time.Hour,
+	}
+
+	if fetched, err := queue.drain(context.Background()); err != nil || fetched != 1 {
+		t.Errorf("expected 1 receipt to be fetched, got %d (%v)", fetched, err)
+	}
+
+	if receipt := storage.receipts[1]; receipt == nil || receipt.Receipt.TotalSum != 100 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	if operation := storage.tradingOperations[1]; operation.Username != "test" {
+		t.Errorf("unexpected trading operation: %+v", operation)
+	}
+
+	if len(storage.purchasedSecurities) != 2 {
+		t.Errorf("expected 2 purchased securities, got %d", len(storage.purchasedSecurities))
+	}
+
+	for _, security := range storage.purchasedSecurities {
+		if security.Username != "test" ||
+			security.Ticker == "AAPL" && (security.BrokerAccountType != "TinkoffIis" || security.PortfolioCurrency != "USD") {
+			t.Errorf("unexpected purchased security: %+v", security)
+		}
+	}
+
+	if calls := server.Calls("/portfolio/purchased_securities"); calls != 4 {
+		t.Errorf("expected purchased securities to be requested for 4 account & currency pairs, got %d", calls)
+	}
+
+	if len(storage.candles["TCSG"]) != 2 {
+		t.Errorf("expected 2 candles, got %d", len(storage.candles["TCSG"]))
+	}
+
+	for _, candle := range storage.candles["TCSG"] {
+		if candle.Username != "test" {
+			t.Errorf("unexpected candle: %+v", candle)
+		}
+	}
+}
+
+func TestChapters_ShoppingReceiptRateLimit(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+		map[string]any{"id": "5002", "name": "Credit", "accountType": "Credit"},
+	}
+
+	server.Operations["5001"] = []any{testOperation(1, "5001", now.Add(-72*time.Hour), true, true)}
+	server.Operations["5002"] = []any{testOperation(2, "5002", now.Add(-72*time.Hour), true, true)}
+	for _, id := range []uint64{1, 2} {
+		server.ShoppingReceipts[id] = map[string]any{"receipt": map[string]any{"totalSum": 100.0}}
+	}
+
+	storage := newMemoryStorage()
+	cvs, logger := newTestCanvas(t, server, storage)
+	cvs.clock = syncf.ClockFunc(func() time.Time { return now })
+	run(context.Background(), cvs)
+
+	if problems := logger.problems(); len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	if len(storage.queue) != 2 {
+		t.Errorf("expected 2 queued receipts, got %+v", storage.queue)
+	}
+
+	if calls := server.Calls("shopping_receipt"); calls != 0 {
+		t.Errorf("expected receipts not to be fetched during sync, got %d calls", calls)
+	}
+
+	queue := receiptQueue{
+		Client:           cvs.Client,
+		StorageInterface: storage,
+		clock:            syncf.ClockFunc(func() time.Time { return now }),
+		username:         "test",
+		backoff:          time.Minute,
+		maxBackoff:       time.Hour,
+	}
+
+	server.Limits["shopping_receipt"] = 1
+	fetched, err := queue.drain(context.Background())
+	if fetched != 1 || !errors.Is(err, tinkoff.ErrRequestRateLimitExceeded) {
+		t.Errorf("expected drain to stop on rate limit after 1 receipt, got %d (%v)", fetched, err)
+	}
+
+	if queued, ok := storage.queue[2]; !ok || queued.Attempts != 1 || !queued.NextAttempt.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected receipt 2 to be postponed, got %+v", storage.queue)
+	}
+
+	if _, err := queue.drain(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if calls := server.Calls("shopping_receipt"); calls != 2 {
+		t.Errorf("expected postponed receipt not to be retried before backoff, got %d calls", calls)
+	}
+
+	server.Limits["shopping_receipt"] = 10
+	queue.clock = syncf.ClockFunc(func() time.Time { return now.Add(time.Minute) })
+	if fetched, err := queue.drain(context.Background()); err != nil || fetched != 1 {
+		t.Errorf("expected postponed receipt to be fetched, got %d (%v)", fetched, err)
+	}
+
+	if len(storage.queue) != 0 || len(storage.receipts) != 2 {
+		t.Errorf("expected all receipts to be fetched, got queue %+v and %d receipts", storage.queue, len(storage.receipts))
+	}
+
+	for attempts, delay := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 7: time.Hour, 100: time.Hour} {
+		if actual := queue.delay(attempts); actual != delay {
+			t.Errorf("expected delay %s after %d attempts, got %s", delay, attempts, actual)
+		}
+	}
+}
+
+type failingReceiptStorage struct {
+	*memoryStorage
+	operationID uint64
+}
+
+func (s failingReceiptStorage) StoreShoppingReceipt(ctx context.Context, receipt *tinkoff.ShoppingReceipt) error {
+	if receipt.OperationID == s.operationID {
+		return errors.New("foreign key violation")
+	}
+
+	return s.memoryStorage.StoreShoppingReceipt(ctx, receipt)
+}
+
+func TestReceiptQueue_StoreError(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	for _, id := range []uint64{1, 2} {
+		server.ShoppingReceipts[id] = map[string]any{"receipt": map[string]any{"totalSum": 100.0}}
+	}
+
+	storage := newMemoryStorage()
+	cvs, _ := newTestCanvas(t, server, storage)
+	// the queue is drained only with a session authorized by sync
+	if _, err := cvs.GetAccounts(context.Background(), tinkoff.Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.QueueShoppingReceipts(context.Background(), "test", []uint64{1, 2}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := receiptQueue{
+		Client:           cvs.Client,
+		StorageInterface: failingReceiptStorage{memoryStorage: storage, operationID: 1},
+		clock:            syncf.ClockFunc(func() time.Time { return now }),
+		username:         "test",
+		backoff:          time.Minute,
+		maxBackoff:       time.Hour,
+	}
+
+	if fetched, err := queue.drain(context.Background()); err != nil || fetched != 1 {
+		t.Errorf("expected drain to continue after store error, got %d (%v)", fetched, err)
+	}
+
+	queued, ok := storage.queue[1]
+	if !ok || queued.Attempts != 1 || !queued.NextAttempt.Equal(now.Add(time.Minute)) ||
+		!strings.Contains(queued.LastError, "foreign key violation") {
+		t.Errorf("expected receipt 1 to be postponed, got %+v", storage.queue)
+	}
+
+	if _, ok := storage.queue[2]; ok || storage.receipts[2] == nil {
+		t.Errorf("expected receipt 2 to be fetched, got queue %+v", storage.queue)
+	}
+}
+
+func TestReceiptQueue_NoSession(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	ids := []uint64{1, 2, 3}
+	for _, id := range ids {
+		server.ShoppingReceipts[id] = map[string]any{"receipt": map[string]any{"totalSum": 100.0}}
+	}
+
+	ctx := context.Background()
+	storage := newMemoryStorage()
+	cvs, _ := newTestCanvas(t, server, storage)
+	if _, err := cvs.GetAccounts(ctx, tinkoff.Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.QueueShoppingReceipts(ctx, "test", ids, now); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := receiptQueue{
+		Client:           cvs.Client,
+		StorageInterface: storage,
+		clock:            syncf.ClockFunc(func() time.Time { return now }),
+		username:         "test",
+		backoff:          time.Minute,
+		maxBackoff:       time.Hour,
+	}
+
+	server.ExpireSessions()
+	authorizations := server.Calls("session")
+	for i := 0; i < 2; i++ {
+		if fetched, err := queue.drain(ctx); fetched != 0 || !errors.Is(err, tinkoff.ErrNoSession) {
+			t.Fatalf("expected drain to stop without session, got %d (%v)", fetched, err)
+		}
+	}
+
+	if calls := server.Calls("session") - authorizations; calls != 0 {
+		t.Errorf("expected drain not to authorize, got %d authorizations", calls)
+	}
+
+	if calls := server.Calls("shopping_receipt"); calls != 1 {
+		t.Errorf("expected drain to stop after the first auth error, got %d calls", calls)
+	}
+
+	for _, id := range ids {
+		if queued := storage.queue[id]; queued.Attempts != 0 || !queued.NextAttempt.Equal(now) {
+			t.Errorf("expected receipt %d not to be postponed, got %+v", id, queued)
+		}
+	}
+
+	// sync authorizes a new session
+	if _, err := cvs.GetAccounts(ctx, tinkoff.Accounts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched, err := queue.drain(ctx); err != nil || fetched != len(ids) {
+		t.Errorf("expected all receipts to be fetched after authorization, got %d (%v)", fetched, err)
+	}
+}
+
+func TestCandlesChapter_ClosedPosition(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	buyTime, sellTime := now.Add(-30*24*time.Hour), now.Add(-10*24*time.Hour)
+	server.Candles["TCSG"] = []any{
+		map[string]any{"date": now.Unix(), "o": 1000.0, "c": 1050.0, "h": 1060.0, "l": 990.0, "v": 100.0},
+	}
+
+	server.Candles["YNDX"] = []any{
+		map[string]any{"date": sellTime.Unix(), "o": 2000.0, "c": 2050.0, "h": 2060.0, "l": 1990.0, "v": 100.0},
+	}
+
+	storage := newMemoryStorage()
+	storage.candles["TCSG"] = map[time.Time]tinkoff.Candle{
+		now: {Username: "test", Ticker: "TCSG", Date: tinkoff.CandleDate(now)},
+	}
+
+	storage.positions = []TradingPosition{
+		{Ticker: "TCSG", BuyTime: &buyTime},
+		{Ticker: "YNDX", BuyTime: &buyTime, SellTime: &sellTime},
+	}
+
+	cvs, logger := newTestCanvas(t, server, storage)
+	if _, err := (candlesChapter{}).sync(context.Background(), &cvs); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := logger.problems(); len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	if len(storage.candles["YNDX"]) != 1 {
+		t.Errorf("expected candles for position closed before the latest TCSG candle, got %+v", storage.candles["YNDX"])
+	}
+}
+
+func TestChapters_Budgets(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, tinkoff.MoscowLocation)
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+	}
+
+	server.Operations["5001"] = []any{
+		testOperation(1, "5001", now.Add(-time.Hour), true, false),
+		testOperation(2, "5001", now.AddDate(0, -1, 0), true, false),
+	}
+
+	storage := newMemoryStorage()
+	cvs, logger := newTestCanvas(t, server, storage)
+	cvs.clock = syncf.ClockFunc(func() time.Time { return now })
+	cvs.budgets = []Budget{
+		{Category: "Супермаркеты", Limit: 120},
+		{MCC: "5411", Limit: 50},
+		{MCC: "5812", Limit: 100},
+	}
+
+	cvs.notify = func(context.Context, string) error { return errors.New("telegram is down") }
+	run(context.Background(), cvs)
+	if len(storage.budgetAlerts) != 0 {
+		t.Fatalf("expected alerts not to be stored when sending fails, got %+v", storage.budgetAlerts)
+	}
+
+	logger.reset()
+	var alerts []string
+	cvs.notify = func(_ context.Context, text string) error {
+		alerts = append(alerts, text)
+		return nil
+	}
+
+	run(context.Background(), cvs)
+	if problems := logger.problems(); len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %v", alerts)
+	}
+
+	june := time.Date(2022, 6, 1, 0, 0, 0, 0, tinkoff.MoscowLocation)
+	for _, alert := range []BudgetAlert{
+		{Username: "test", Budget: "Супермаркеты", Period: june, Threshold: 80},
+		{Username: "test", Budget: "MCC 5411", Period: june, Threshold: 80},
+		{Username: "test", Budget: "MCC 5411", Period: june, Threshold: 100},
+	} {
+		if !storage.budgetAlerts[alert] {
+			t.Errorf("expected alert to be stored: %+v", alert)
+		}
+	}
+
+	server.Operations["5001"] = append(server.Operations["5001"], testOperation(3, "5001", now, true, false))
+	alerts = nil
+	run(context.Background(), cvs)
+	if len(alerts) != 1 || !strings.HasPrefix(alerts[0], "🎯 Супермаркеты") {
+		t.Errorf("expected only 100%% alert for category budget, got %v", alerts)
+	}
+}
+
+func TestChapters_Watch(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+	}
+
+	withMerchant := func(operation map[string]any, name, country string) map[string]any {
+		operation["merchant"] = map[string]any{"name": name, "region": map[string]any{"country": country}}
+		return operation
+	}
+
+	server.Operations["5001"] = []any{
+		withMerchant(testOperation(1, "5001", now.Add(-72*time.Hour), true, false), "Shop", "RUS"),
+	}
+
+	storage := newMemoryStorage()
+	cvs, logger := newTestCanvas(t, server, storage)
+	cvs.watch = WatchRules{Amount: 1000, HomeCountry: "RUS", CardNotPresent: true, NewMerchant: true}
+
+	var announcements []string
+	cvs.notify = func(_ context.Context, text string) error {
+		announcements = append(announcements, text)
+		return nil
+	}
+
+	run(context.Background(), cvs)
+	if len(announcements) != 0 {
+		t.Fatalf("expected no announcements on initial sync, got %v", announcements)
+	}
+
+	large := testOperation(2, "5001", now.Add(-4*time.Hour), true, false)
+	large["accountAmount"] = map[string]any{"currency": map[string]any{"name": "RUB"}, "value": 5000.0}
+	notPresent := testOperation(4, "5001", now.Add(-2*time.Hour), true, false)
+	notPresent["cardPresent"] = false
+	server.Operations["5001"] = append(server.Operations["5001"],
+		large,
+		withMerchant(testOperation(3, "5001", now.Add(-3*time.Hour), true, false), "Shop", "USA"),
+		notPresent,
+		withMerchant(testOperation(5, "5001", now.Add(-time.Hour), false, false), "Cafe", "RUS"),
+		withMerchant(testOperation(6, "5001", now.Add(-time.Hour), false, false), "Shop", "RUS"))
+
+	cvs.notify = func(context.Context, string) error { return errors.New("telegram is down") }
+	run(context.Background(), cvs)
+	if len(storage.announcements) != 4 {
+		t.Errorf("expected 4 pending announcements, got %+v", storage.announcements)
+	}
+
+	for id, announcement := range storage.announcements {
+		if !announcement.Pending {
+			t.Errorf("expected operation %d announcement to stay pending when sending fails", id)
+		}
+	}
+
+	logger.reset()
+	cvs.notify = func(_ context.Context, text string) error {
+		announcements = append(announcements, text)
+		return nil
+	}
+
+	run(context.Background(), cvs)
+	if problems := logger.problems(); len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	if len(announcements) != 4 {
+		t.Fatalf("expected 4 announcements, got %v", announcements)
+	}
+
+	for _, id := range []uint64{2, 3, 4, 5} {
+		if announcement, ok := storage.announcements[id]; !ok || announcement.Pending {
+			t.Errorf("expected operation %d to be announced", id)
+		}
+	}
+
+	announcements = nil
+	run(context.Background(), cvs)
+	if len(announcements) != 0 {
+		t.Errorf("expected no repeated announcements, got %v", announcements)
+	}
+}
+
+// merchantCheckStorage counts new merchant checks.
+type merchantCheckStorage struct {
+	*memoryStorage
+	checks *int
+}
+
+func (s merchantCheckStorage) IsFirstMerchantOperation(ctx context.Context, username string, operation tinkoff.Operation) (bool, error) {
+	*s.checks++
+	return s.memoryStorage.IsFirstMerchantOperation(ctx, username, operation)
+}
+
+func TestChapters_WatchPendingOutsideWindow(t *testing.T) {
+	now := time.Now()
+	server := tinkofftest.NewServer()
+	defer server.Close()
+
+	server.Accounts = []any{
+		map[string]any{"id": "5001", "name": "Debit", "accountType": "Current"},
+	}
+
+	initial := testOperation(1, "5001", now.Add(-72*time.Hour), true, false)
+	server.Operations["5001"] = []any{initial}
+
+	checks := 0
+	storage := newMemoryStorage()
+	cvs, _ := newTestCanvas(t, server, storage)
+	cvs.StorageInterface = merchantCheckStorage{memoryStorage: storage, checks: &checks}
+	cvs.watch = WatchRules{Amount: 1000, NewMerchant: true}
+	run(context.Background(), cvs)
+
+	large := testOperation(2, "5001", now.Add(-4*time.Hour), true, false)
+	large["accountAmount"] = map[string]any{"currency": map[string]any{"name": "RUB"}, "value": 5000.0}
+	large["merchant"] = map[string]any{"name": "Shop"}
+	server.Operations["5001"] = []any{initial, large}
+
+	cvs.notify = func(context.Context, string) error { return errors.New("telegram is down") }
+	run(context.Background(), cvs)
+	if announcement := storage.announcements[2]; !announcement.Pending {
+		t.Fatalf("expected operation 2 announcement to be pending, got %+v", announcement)
+	}
+
+	// the operation is not returned in the refresh window anymore
+	server.Operations["5001"] = []any{initial}
+
+	var announcements []string
+	cvs.notify = func(_ context.Context, text string) error {
+		announcements = append(announcements, text)
+		return nil
+	}
+
+	run(context.Background(), cvs)
+	if len(announcements) != 1 || !strings.Contains(announcements[0], "amount over 1000.00") {
+		t.Fatalf("expected pending announcement to be sent, got %v", announcements)
+	}
+
+	if checks != 1 {
+		t.Errorf("expected watch rules to be checked once, got %d checks", checks)
+	}
+}
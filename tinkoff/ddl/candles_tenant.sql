@@ -0,0 +1,31 @@
+do
+$$
+    begin
+        if exists(select 1
+                  from information_schema.tables
+                  where table_schema = current_schema()
+                    and table_name = 'candles')
+            and not exists(select 1
+                           from information_schema.columns
+                           where table_schema = current_schema()
+                             and table_name = 'candles'
+                             and column_name = 'username') then
+            alter table candles
+                drop constraint candles_pkey;
+            alter table candles
+                add column username text;
+            insert into candles (username, ticker, date, open, close, high, low, volume)
+            select distinct t.username, c.ticker, c.date, c.open, c.close, c.high, c.low, c.volume
+            from candles c
+                     inner join trading_operations t on c.ticker = t.ticker
+            where c.username is null;
+            delete
+            from candles
+            where username is null;
+            alter table candles
+                alter column username set not null;
+            alter table candles
+                add primary key (username, ticker, date);
+        end if;
+    end
+$$;
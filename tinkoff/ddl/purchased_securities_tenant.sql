@@ -0,0 +1,41 @@
+do
+$$
+    begin
+        if exists(select 1
+                  from information_schema.tables
+                  where table_schema = current_schema()
+                    and table_name = 'purchased_securities')
+            and not exists(select 1
+                           from information_schema.columns
+                           where table_schema = current_schema()
+                             and table_name = 'purchased_securities'
+                             and column_name = 'username') then
+            alter table purchased_securities
+                drop constraint purchased_securities_pkey;
+            alter table purchased_securities
+                add column username            text,
+                add column broker_account_type text    not null default 'Tinkoff',
+                add column portfolio_currency  char(3) not null default 'RUB';
+            insert into purchased_securities (username, broker_account_type, portfolio_currency,
+                                              ticker, security_type, currency, value, "time")
+            select distinct t.username,
+                            p.broker_account_type,
+                            p.portfolio_currency,
+                            p.ticker,
+                            p.security_type,
+                            p.currency,
+                            p.value,
+                            p."time"
+            from purchased_securities p
+                     inner join trading_operations t on p.ticker = t.ticker
+            where p.username is null;
+            delete
+            from purchased_securities
+            where username is null;
+            alter table purchased_securities
+                alter column username set not null;
+            alter table purchased_securities
+                add primary key (username, broker_account_type, portfolio_currency, ticker, security_type, "time");
+        end if;
+    end
+$$;
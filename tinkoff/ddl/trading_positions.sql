@@ -5,9 +5,9 @@ with events_cum_q as (select username,
                              "time",
                              ticker,
                              currency,
-                             quantity * (- sign(payment))                                             as quantity,
+                             quantity * (- sign(payment))                                                       as quantity,
                              price,
-                             sum(quantity * (- sign(payment))) over (partition by ticker order by id) as quantity_cum
+                             sum(quantity * (- sign(payment))) over (partition by username, ticker order by id) as quantity_cum
                       from trading_operations
                       where instrument_type = 'Stock'
                         and (type in ('Buy', 'Sell'))),
@@ -22,6 +22,7 @@ with events_cum_q as (select username,
                           (select y.id
                            from events_cum_q y
                            where y.quantity_cum > 0
+                             and y.username = x.username
                              and y.ticker = x.ticker
                              and y.id <= x.id
                              and y.quantity_cum = y.quantity
@@ -46,19 +47,20 @@ with events_cum_q as (select username,
                               quantity_cum,
                               buy_id,
                               generate_series,
-                              price                                               as buy_price,
-                              "time"                                              as buy_time,
-                              row_number() over (partition by ticker order by id) as rn
+                              price                                                         as buy_price,
+                              "time"                                                        as buy_time,
+                              row_number() over (partition by username, ticker order by id) as rn
                        from events_id,
                             lateral generate_series(1, abs(quantity)) generate_series(generate_series)
                        where quantity > 0) x
-                    full join (select ticker,
-                                      price                                               as sell_price,
-                                      "time"                                              as sell_time,
-                                      row_number() over (partition by ticker order by id) as rn
+                    full join (select username,
+                                      ticker,
+                                      price                                                         as sell_price,
+                                      "time"                                                        as sell_time,
+                                      row_number() over (partition by username, ticker order by id) as rn
                                from events_id,
                                     lateral generate_series(1, abs(quantity)) generate_series(generate_series)
-                               where quantity < 0) y using (rn, ticker)) t)
+                               where quantity < 0) y using (rn, username, ticker)) t)
 select events.ticker,
        events.currency,
        events.buy_time,
@@ -68,9 +70,10 @@ select events.ticker,
        events.sell_price,
        events.username
 from events
-         join (select ticker,
+         join (select username,
+                      ticker,
                       max(rn)  as rn,
                       count(1) as quantity
                from events
-               group by ticker, buy_time, sell_time) t using (rn, ticker)
+               group by username, ticker, buy_time, sell_time) t using (rn, username, ticker)
 order by (sell_time is not null), ticker, buy_time, sell_time;
\ No newline at end of file
@@ -101,6 +101,12 @@ func (l *telegramLogger) sub(name string) logger {
 	}
 }
 
+func (l *telegramLogger) empty() bool {
+	_, cancel := l.mu.Lock(context.Background())
+	defer cancel()
+	return len(l.chapters) == 0
+}
+
 func (l *telegramLogger) flush() {
 	if l.name != "" {
 		return
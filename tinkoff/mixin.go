@@ -21,9 +21,34 @@ import (
 
 type (
 	Config struct {
-		DB          apfel.GormConfig           `yaml:"db" doc:"This database will be used for saving bank data. Tables and views will be created automatically. Only 'postgres' driver is supported."`
-		Credentials map[telegram.ID]Credential `yaml:"credentials" doc:"User credentials so you don't have to enter your password each time you want to sync data. Keys are telegram user IDs and values are credentials.\nOnly users with IDs found in this map will be allowed to execute /update_bank_statement (they still need to receive and enter confirmation code, though)."`
-		Overlap     flu.Duration               `yaml:"overlap,omitempty" doc:"Minimum amount of data to be reloaded each time." default:"24h"`
+		DB          apfel.GormConfig             `yaml:"db" doc:"This database will be used for saving bank data. Tables and views will be created automatically. Only 'postgres' driver is supported."`
+		Credentials map[telegram.ID]Credential   `yaml:"credentials" doc:"User credentials so you don't have to enter your password each time you want to sync data. Keys are telegram user IDs and values are credentials.\nOnly users with IDs found in this map will be allowed to execute /update_bank_statement (they still need to receive and enter confirmation code, though)."`
+		Overlap     flu.Duration                 `yaml:"overlap,omitempty" doc:"Minimum amount of data to be reloaded each time." default:"24h"`
+		Schedule    map[telegram.ID]flu.Duration `yaml:"schedule,omitempty" doc:"Background sync intervals. Keys are telegram user IDs (which must be present in credentials) and values are intervals between syncs.\nThe report is sent only when some of the chapters produced warnings or errors."`
+		Budgets     map[telegram.ID][]Budget     `yaml:"budgets,omitempty" doc:"Monthly budgets. Keys are telegram user IDs (which must be present in credentials).\nAn alert is sent once per month when 80% and 100% of a budget is spent."`
+		PriceJump   float64                      `yaml:"priceJump,omitempty" doc:"Minimum price increase (in percent) of the latest purchase of a regularly bought good\nagainst its average price to be reported by /receipts jumps." default:"20"`
+		Receipts    ReceiptQueue                 `yaml:"receipts,omitempty" doc:"Shopping receipts of synced operations are queued and fetched by a background worker within rate limits.\nFailed fetches are retried with exponential backoff."`
+		Watch       map[telegram.ID]WatchRules   `yaml:"watch,omitempty" doc:"Rules for new operations to be announced. Keys are telegram user IDs (which must be present in credentials).\nEach matching operation is announced once. Failed announcements are retried on the next sync."`
+	}
+
+	WatchRules struct {
+		Amount         float64 `yaml:"amount,omitempty" doc:"Announce operations with amount (in account currency) not less than this value."`
+		HomeCountry    string  `yaml:"homeCountry,omitempty" doc:"Announce operations with merchants outside of this country (ISO 3166-1 alpha-3 code)."`
+		CardNotPresent bool    `yaml:"cardNotPresent,omitempty" doc:"Announce card-not-present operations."`
+		NewMerchant    bool    `yaml:"newMerchant,omitempty" doc:"Announce first operations with each merchant."`
+	}
+
+	ReceiptQueue struct {
+		Interval   flu.Duration        `yaml:"interval,omitempty" doc:"Interval between receipt queue checks." default:"1m" format:"duration"`
+		Backoff    flu.Duration        `yaml:"backoff,omitempty" doc:"Delay before the first retry of a failed receipt fetch. It is doubled after each failed attempt." default:"5m" format:"duration"`
+		MaxBackoff flu.Duration        `yaml:"maxBackoff,omitempty" doc:"Maximum delay between receipt fetch attempts." default:"24h" format:"duration"`
+		RateLimits []tinkoff.RateLimit `yaml:"rateLimits,omitempty" doc:"Shopping receipt request rate limits. 25 requests per 75s and 75 requests per 11m are used by default."`
+	}
+
+	Budget struct {
+		Category string  `yaml:"category,omitempty" doc:"Spending category name. Either category or mcc must be set."`
+		MCC      string  `yaml:"mcc,omitempty" doc:"Merchant category code. Either category or mcc must be set."`
+		Limit    float64 `yaml:"limit" doc:"Monthly limit in rubles."`
 	}
 
 	Context interface {
@@ -33,13 +58,30 @@ type (
 
 	Mixin[C Context] struct {
 		app         apfel.MixinApp[C]
-		telegram    tapp.Mixin[C]
+		telegram    *tapp.Mixin[C]
 		storage     Storage[C]
 		credentials map[telegram.ID]Credential
 		overlap     time.Duration
+		budgets     map[telegram.ID][]Budget
+		watch       map[telegram.ID]WatchRules
+		priceJump   float64
+		receipts    ReceiptQueue
+		mu          map[telegram.ID]syncf.Locker
 	}
 )
 
+func (b Budget) String() string {
+	if b.MCC != "" {
+		return "MCC " + b.MCC
+	}
+
+	return b.Category
+}
+
+func (r WatchRules) enabled() bool {
+	return r.Amount > 0 || r.HomeCountry != "" || r.CardNotPresent || r.NewMerchant
+}
+
 func (m Mixin[C]) String() string {
 	return "tinkoff"
 }
@@ -49,16 +91,90 @@ func (m *Mixin[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return err
 	}
 
-	if err := app.Use(ctx, &m.telegram, false); err != nil {
+	m.telegram = new(tapp.Mixin[C])
+	if err := app.Use(ctx, m.telegram, false); err != nil {
 		return err
 	}
 
 	config := app.Config().TinkoffConfig()
 	m.credentials = config.Credentials
 	m.overlap = config.Overlap.Value
+	m.mu = make(map[telegram.ID]syncf.Locker, len(m.credentials))
+	for userID := range m.credentials {
+		m.mu[userID] = syncf.Semaphore(nil, 1, 0)
+	}
+
+	for userID, budgets := range config.Budgets {
+		if _, ok := m.credentials[userID]; !ok {
+			return errors.Errorf("no credentials for budget user ID %s", userID)
+		}
+
+		for _, budget := range budgets {
+			if (budget.Category == "") == (budget.MCC == "") {
+				return errors.Errorf("exactly one of category or mcc must be set in budget for user ID %s", userID)
+			}
+
+			if budget.Limit <= 0 {
+				return errors.Errorf("budget limit for %s must be positive", budget)
+			}
+		}
+	}
+
+	for userID := range config.Watch {
+		if _, ok := m.credentials[userID]; !ok {
+			return errors.Errorf("no credentials for watch user ID %s", userID)
+		}
+	}
+
+	m.budgets = config.Budgets
+	m.watch = config.Watch
+	m.priceJump = config.PriceJump
+	m.receipts = config.Receipts
+	if m.receipts.Interval.Value <= 0 || m.receipts.Backoff.Value <= 0 || m.receipts.MaxBackoff.Value < m.receipts.Backoff.Value {
+		return errors.New("receipt queue interval and backoff must be positive and not exceed max backoff")
+	}
+
+	for _, rateLimit := range m.receipts.RateLimits {
+		if rateLimit.Requests <= 0 || rateLimit.Interval.Value <= 0 {
+			return errors.New("receipt rate limit requests and interval must be positive")
+		}
+	}
 
 	m.app = app
 
+	for userID, interval := range config.Schedule {
+		if _, ok := m.credentials[userID]; !ok {
+			return errors.Errorf("no credentials for scheduled user ID %s", userID)
+		}
+
+		if interval.Value <= 0 {
+			continue
+		}
+
+		userID, interval := userID, interval.Value
+		if err := app.Manage(ctx, scheduler(syncf.GoSync(context.Background(), func(ctx context.Context) {
+			m.schedule(ctx, userID, interval)
+		}))); err != nil {
+			return err
+		}
+	}
+
+	for userID := range m.credentials {
+		userID := userID
+		if err := app.Manage(ctx, scheduler(syncf.GoSync(context.Background(), func(ctx context.Context) {
+			m.drainReceipts(ctx, userID)
+		}))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type scheduler func()
+
+func (s scheduler) Close() error {
+	s()
 	return nil
 }
 
@@ -83,16 +199,6 @@ func (m *Mixin[C]) Update_bank_statement(ctx context.Context, _ telegram.Client,
 		return errors.New("invalid user ID")
 	}
 
-	logf.Get(m).Debugf(ctx, "got credentials for [%s]", credential.Username)
-
-	client := tinkoff.Client[C]{
-		Credential: credential,
-	}
-
-	if err := m.app.Use(ctx, &client, false); err != nil {
-		return err
-	}
-
 	html := ext.HTML(context.Background(), m.telegram.Bot(), cmd.User.ID)
 	defer func() {
 		if err := html.Flush(); err != nil {
@@ -119,19 +225,136 @@ func (m *Mixin[C]) Update_bank_statement(ctx context.Context, _ telegram.Client,
 	logger := newTelegramLogger(html)
 	defer logger.flush()
 
+	return m.update(ctx, cmd.User.ID, logger)
+}
+
+func (m *Mixin[C]) schedule(ctx context.Context, userID telegram.ID, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		username := m.credentials[userID].Username
+		html := ext.HTML(context.Background(), m.telegram.Bot(), userID)
+		logger := newTelegramLogger(html)
+		if err := m.update(ctx, userID, logger); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logf.Get(m).Warnf(ctx, "scheduled update for [%s]: %v", username, err)
+			logger.sub("🔄 Sync").errorf(ctx, "%v", err)
+		}
+
+		if logger.empty() {
+			logf.Get(m).Debugf(ctx, "scheduled update for [%s] completed", username)
+			continue
+		}
+
+		logger.flush()
+		if err := html.Flush(); err != nil {
+			logf.Get(m).Errorf(ctx, "send scheduled update report to [%s]: %v", username, err)
+		}
+	}
+}
+
+func (m *Mixin[C]) update(ctx context.Context, userID telegram.ID, logger *telegramLogger) error {
+	credential := m.credentials[userID]
+	ctx, cancel := m.mu[userID].Lock(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else {
+		defer cancel()
+	}
+
+	logf.Get(m).Debugf(ctx, "got credentials for [%s]", credential.Username)
+
+	client, err := m.client(ctx, userID)
+	if err != nil {
+		return err
+	}
+
 	cvs := canvas{
-		Client:           &client,
+		Client:           client,
 		StorageInterface: &m.storage,
 		logger:           logger,
+		clock:            m.app,
 		username:         credential.Username,
 		overlap:          m.overlap,
+		budgets:          m.budgets[userID],
+		watch:            m.watch[userID],
+		notify: func(ctx context.Context, text string) error {
+			_, err := m.telegram.Bot().Send(ctx, userID, &telegram.Text{Text: text}, nil)
+			return err
+		},
+	}
+
+	run(ctx, cvs)
+	return nil
+}
+
+func (m *Mixin[C]) client(ctx context.Context, userID telegram.ID) (*tinkoff.Client[C], error) {
+	client := &tinkoff.Client[C]{
+		Credential:                m.credentials[userID],
+		SessionStorage:            &m.storage,
+		ShoppingReceiptRateLimits: m.receipts.RateLimits,
+	}
+
+	if err := m.app.Use(ctx, client, false); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (m *Mixin[C]) drainReceipts(ctx context.Context, userID telegram.ID) {
+	ticker := time.NewTicker(m.receipts.Interval.Value)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		username := m.credentials[userID].Username
+		client, err := m.client(ctx, userID)
+		if err != nil {
+			logf.Get(m).Warnf(ctx, "create client for [%s]: %v", username, err)
+			continue
+		}
+
+		queue := receiptQueue{
+			Client:           client,
+			StorageInterface: &m.storage,
+			clock:            m.app,
+			username:         username,
+			backoff:          m.receipts.Backoff.Value,
+			maxBackoff:       m.receipts.MaxBackoff.Value,
+		}
+
+		fetched, err := queue.drain(ctx)
+		switch {
+		case errors.Is(err, tinkoff.ErrNoSession):
+			logf.Get(m).Debugf(ctx, "drain receipt queue for [%s]: %v", username, err)
+		case err != nil:
+			logf.Get(m).Warnf(ctx, "drain receipt queue for [%s]: %v", username, err)
+		}
+
+		if fetched > 0 {
+			logf.Get(m).Infof(ctx, "fetched %d receipts for [%s]", fetched, username)
+		}
 	}
+}
 
+func run(ctx context.Context, cvs canvas) {
 	for _, chapter := range defaultChapters {
 		sync(ctx, cvs, chapter)
 	}
-
-	return nil
 }
 
 func sync(ctx context.Context, cvs canvas, chapter chapter) {
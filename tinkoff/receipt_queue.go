@@ -0,0 +1,98 @@
+package tinkoff
+
+import (
+	"context"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+
+	"github.com/jfk9w-go/flu/syncf"
+	"github.com/pkg/errors"
+)
+
+// receiptQueue fetches queued shopping receipts retrying failed fetches with exponential backoff.
+type receiptQueue struct {
+	Client
+	StorageInterface
+	clock      syncf.Clock
+	username   string
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// delay returns the delay before the next attempt after the given number of failed attempts.
+func (q receiptQueue) delay(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+
+	return delay
+}
+
+func (q receiptQueue) postpone(ctx context.Context, receipt QueuedShoppingReceipt, cause error) error {
+	receipt.Attempts++
+	receipt.LastError = cause.Error()
+	receipt.NextAttempt = q.clock.Now().Add(q.delay(receipt.Attempts))
+	return q.UpdateQueuedShoppingReceipt(ctx, receipt)
+}
+
+// drain fetches all due shopping receipts from the queue.
+// Receipts which failed to be fetched or stored are postponed.
+// It stops on rate limit and context errors.
+// User confirmation is never requested: drain stops if there is no valid session
+// and the queue waits for the next sync to authorize.
+func (q receiptQueue) drain(ctx context.Context) (fetched int, err error) {
+	receipts, err := q.GetQueuedShoppingReceipts(ctx, q.username, q.clock.Now())
+	if err != nil {
+		return 0, errors.Wrap(err, "get queued receipts")
+	}
+
+	for _, queued := range receipts {
+		receipt, err := q.GetShoppingReceipt(tinkoff.WithoutAuthorization(ctx), tinkoff.OperationReceipt{
+			OperationID: queued.OperationID,
+		})
+
+		switch {
+		case err == nil:
+			if err := q.StoreShoppingReceipt(ctx, receipt); err != nil {
+				if err := q.postpone(ctx, queued, errors.Wrap(err, "store")); err != nil {
+					return fetched, errors.Wrapf(err, "postpone receipt %d", queued.OperationID)
+				}
+
+				continue
+			}
+
+			fetched++
+		case errors.Is(err, tinkoff.ErrNoDataFound):
+			if err := q.RemoveShoppingReceiptFlag(ctx, queued.OperationID); err != nil {
+				return fetched, errors.Wrapf(err, "remove receipt flag %d", queued.OperationID)
+			}
+		case syncf.IsContextRelated(err):
+			return fetched, err
+		case errors.Is(err, tinkoff.ErrNoSession), errors.Is(err, tinkoff.ErrInsufficientPrivileges):
+			// the receipt is not postponed since the failure is not related to it
+			return fetched, errors.Wrapf(err, "retrieve receipt %d", queued.OperationID)
+		default:
+			if err := q.postpone(ctx, queued, err); err != nil {
+				return fetched, errors.Wrapf(err, "postpone receipt %d", queued.OperationID)
+			}
+
+			if errors.Is(err, tinkoff.ErrRequestRateLimitExceeded) {
+				return fetched, errors.Wrapf(err, "retrieve receipt %d", queued.OperationID)
+			}
+
+			continue
+		}
+
+		if err := q.RemoveQueuedShoppingReceipt(ctx, queued.OperationID); err != nil {
+			return fetched, errors.Wrapf(err, "remove receipt %d from queue", queued.OperationID)
+		}
+	}
+
+	return fetched, nil
+}
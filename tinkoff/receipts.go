@@ -0,0 +1,169 @@
+package tinkoff
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext"
+	"github.com/jfk9w-go/telegram-bot-api/ext/html"
+	"github.com/pkg/errors"
+)
+
+const (
+	// minRegularPurchases is the number of purchases of a good for it to be considered bought regularly.
+	minRegularPurchases = 3
+	// priceJumpMonths is the number of months over which average good prices are calculated.
+	priceJumpMonths = 12
+)
+
+// priceJumps returns latest purchases of regularly bought goods which are more expensive
+// than the average price of previous purchases by more than threshold percent.
+// Purchases must be ordered by good ID and time.
+func priceJumps(purchases []GoodPurchase, threshold float64) []PriceJump {
+	var jumps []PriceJump
+	for start := 0; start < len(purchases); {
+		end := start + 1
+		for end < len(purchases) && purchases[end].GoodID == purchases[start].GoodID {
+			end++
+		}
+
+		good := purchases[start:end]
+		start = end
+		if len(good) < minRegularPurchases {
+			continue
+		}
+
+		var sum float64
+		for _, purchase := range good[:len(good)-1] {
+			sum += purchase.Price
+		}
+
+		jump := PriceJump{
+			GoodPurchase: good[len(good)-1],
+			AvgPrice:     sum / float64(len(good)-1),
+			Count:        len(good),
+		}
+
+		if jump.AvgPrice > 0 && jump.Increase()*100 > threshold {
+			jumps = append(jumps, jump)
+		}
+	}
+
+	sort.SliceStable(jumps, func(i, j int) bool { return jumps[i].Increase() > jumps[j].Increase() })
+	return jumps
+}
+
+// receiptsSubcommand returns /receipts subcommand and its argument.
+// Subcommand may be omitted in favor of top, so the first argument may be a period.
+func receiptsSubcommand(first, second string, now time.Time) (subcommand, arg string) {
+	if _, err := parsePeriod(first, now); err == nil {
+		return "top", first
+	}
+
+	return first, second
+}
+
+func (m *Mixin[C]) Receipts(ctx context.Context, _ telegram.Client, cmd *telegram.Command) error {
+	credential, ok := m.credentials[cmd.User.ID]
+	if !ok {
+		return errors.New("invalid user ID")
+	}
+
+	now := m.app.Now().In(tinkoff.MoscowLocation)
+	html := ext.HTML(ctx, m.telegram.Bot(), cmd.Chat.ID)
+	subcommand, arg := receiptsSubcommand(cmd.Arg(0), cmd.Arg(1), now)
+	switch subcommand {
+	case "top":
+		period, err := parsePeriod(arg, now)
+		if err != nil {
+			return err
+		}
+
+		goods, err := m.storage.GetTopGoods(ctx, credential.Username, period.since, period.until)
+		if err != nil {
+			return errors.Wrap(err, "get top goods")
+		}
+
+		writeTopGoods(html, period, goods)
+	case "price":
+		goodID, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return errors.Errorf("invalid good ID [%s]", arg)
+		}
+
+		prices, err := m.storage.GetGoodPrices(ctx, credential.Username, uint(goodID))
+		if err != nil {
+			return errors.Wrap(err, "get good prices")
+		}
+
+		if len(prices) == 0 {
+			return errors.New("no purchases found")
+		}
+
+		writeGoodPrices(html, prices)
+	case "jumps":
+		purchases, err := m.storage.GetGoodPurchases(ctx, credential.Username, now.AddDate(0, -priceJumpMonths, 0))
+		if err != nil {
+			return errors.Wrap(err, "get good purchases")
+		}
+
+		writePriceJumps(html, m.priceJump, priceJumps(purchases, m.priceJump))
+	default:
+		return errors.Errorf("unknown subcommand [%s], expected top, price or jumps", subcommand)
+	}
+
+	return html.Flush()
+}
+
+func writeTopGoods(html *html.Writer, period period, goods []Good) {
+	html.Bold("🛒 %s", period)
+	if len(goods) == 0 {
+		html.Text("\nNo goods found")
+		return
+	}
+
+	for _, good := range goods {
+		html.Text("\n%s (#%d) – %d purchases (quantity %g), %.2f ₽ total", good.Name, good.GoodID, good.Count, good.Quantity, good.Sum)
+	}
+}
+
+func writeGoodPrices(html *html.Writer, prices []GoodPrice) {
+	last := prices[len(prices)-1]
+	html.Bold("📈 %s", last.Name)
+	for _, price := range prices {
+		html.Text("\n%s – %.2f ₽", price.Month.Format("01.2006"), price.AvgPrice)
+		if price.MinPrice != price.MaxPrice {
+			html.Text(" (%.2f – %.2f ₽)", price.MinPrice, price.MaxPrice)
+		}
+	}
+
+	if first := prices[0]; len(prices) > 1 && first.AvgPrice > 0 {
+		delta := last.AvgPrice/first.AvgPrice - 1
+		icon := "🔺"
+		if delta < 0 {
+			icon = "🔻"
+		}
+
+		html.Text("\n\nChange since %s: %s %.1f%%", first.Month.Format("01.2006"), icon, math.Abs(delta)*100)
+	}
+}
+
+func writePriceJumps(html *html.Writer, threshold float64, jumps []PriceJump) {
+	html.Bold("⚠️ Price jumps over %.0f%%", threshold)
+	if len(jumps) == 0 {
+		html.Text("\nNo price jumps found")
+		return
+	}
+
+	for _, jump := range jumps {
+		html.Text("\n%s (#%d) – %.2f ₽ on %s vs %.2f ₽ avg (🔺 %.1f%%)",
+			jump.Name, jump.GoodID, jump.Price, jump.Time.In(tinkoff.MoscowLocation).Format("02.01.2006"),
+			jump.AvgPrice, jump.Increase()*100)
+	}
+}
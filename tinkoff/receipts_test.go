@@ -0,0 +1,66 @@
+package tinkoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceJumps(t *testing.T) {
+	day := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	purchase := func(goodID uint, price float64, days int) GoodPurchase {
+		return GoodPurchase{GoodID: goodID, Name: "Good", Price: price, Time: day.AddDate(0, 0, days)}
+	}
+
+	jumps := priceJumps([]GoodPurchase{
+		// bought twice only
+		purchase(1, 100, 0),
+		purchase(1, 200, 1),
+		// stable price
+		purchase(2, 100, 0),
+		purchase(2, 110, 1),
+		purchase(2, 115, 2),
+		// +50%
+		purchase(3, 100, 0),
+		purchase(3, 100, 1),
+		purchase(3, 150, 2),
+		// +25%
+		purchase(4, 80, 0),
+		purchase(4, 120, 1),
+		purchase(4, 125, 2),
+		// previous jump is not reported
+		purchase(5, 100, 0),
+		purchase(5, 200, 1),
+		purchase(5, 200, 2),
+		purchase(5, 150, 3),
+	}, 20)
+
+	if len(jumps) != 2 {
+		t.Fatalf("expected 2 jumps, got %d", len(jumps))
+	}
+
+	if jumps[0].GoodID != 3 || jumps[0].AvgPrice != 100 || jumps[0].Count != 3 || !jumps[0].Time.Equal(day.AddDate(0, 0, 2)) {
+		t.Errorf("unexpected first jump: %+v", jumps[0])
+	}
+
+	if jumps[1].GoodID != 4 || jumps[1].Increase() != 0.25 {
+		t.Errorf("unexpected second jump: %+v", jumps[1])
+	}
+}
+
+func TestReceiptsSubcommand(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	for args, expected := range map[[2]string][2]string{
+		{"", ""}:           {"top", ""},
+		{"month", ""}:      {"top", "month"},
+		{"2022-05", ""}:    {"top", "2022-05"},
+		{"top", "week"}:    {"top", "week"},
+		{"price", "42"}:    {"price", "42"},
+		{"jumps", ""}:      {"jumps", ""},
+		{"unknown", "arg"}: {"unknown", "arg"},
+	} {
+		subcommand, arg := receiptsSubcommand(args[0], args[1], now)
+		if [2]string{subcommand, arg} != expected {
+			t.Errorf("expected %v for %v, got [%s %s]", expected, args, subcommand, arg)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tinkoff
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext"
+	"github.com/jfk9w-go/telegram-bot-api/ext/html"
+	"github.com/pkg/errors"
+)
+
+type period struct {
+	since, until time.Time
+	previous     func() period
+}
+
+func (p period) String() string {
+	return p.since.Format("02.01.2006") + " – " + p.until.Add(-time.Nanosecond).Format("02.01.2006")
+}
+
+func monthPeriod(year int, month time.Month, location *time.Location) period {
+	since := time.Date(year, month, 1, 0, 0, 0, 0, location)
+	return period{
+		since:    since,
+		until:    since.AddDate(0, 1, 0),
+		previous: func() period { return monthPeriod(year, month-1, location) },
+	}
+}
+
+func weekPeriod(since time.Time) period {
+	return period{
+		since:    since,
+		until:    since.AddDate(0, 0, 7),
+		previous: func() period { return weekPeriod(since.AddDate(0, 0, -7)) },
+	}
+}
+
+// comparable returns the previous period clipped to the part of this period elapsed by now,
+// so that a period in progress is not compared against a full one.
+func (p period) comparable(now time.Time) period {
+	previous := p.previous()
+	if now.After(p.since) && now.Before(p.until) {
+		if until := previous.since.Add(now.Sub(p.since)); until.Before(previous.until) {
+			previous.until = until
+		}
+	}
+
+	return previous
+}
+
+func parsePeriod(value string, now time.Time) (period, error) {
+	location := now.Location()
+	switch value {
+	case "", "month":
+		return monthPeriod(now.Year(), now.Month(), location), nil
+	case "week":
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+		weekday := (int(today.Weekday()) + 6) % 7
+		return weekPeriod(today.AddDate(0, 0, -weekday)), nil
+	default:
+		month, err := time.ParseInLocation("2006-01", value, location)
+		if err != nil {
+			return period{}, errors.Errorf("invalid period [%s], expected week, month or YYYY-MM", value)
+		}
+
+		return monthPeriod(month.Year(), month.Month(), location), nil
+	}
+}
+
+func (m *Mixin[C]) Spending(ctx context.Context, _ telegram.Client, cmd *telegram.Command) error {
+	credential, ok := m.credentials[cmd.User.ID]
+	if !ok {
+		return errors.New("invalid user ID")
+	}
+
+	now := m.app.Now().In(tinkoff.MoscowLocation)
+	current, err := parsePeriod(cmd.Arg(0), now)
+	if err != nil {
+		return err
+	}
+
+	spending, err := m.storage.GetSpending(ctx, credential.Username, current.since, current.until)
+	if err != nil {
+		return errors.Wrap(err, "get spending")
+	}
+
+	previous := current.comparable(now)
+	previousSpending, err := m.storage.GetSpending(ctx, credential.Username, previous.since, previous.until)
+	if err != nil {
+		return errors.Wrap(err, "get previous spending")
+	}
+
+	html := ext.HTML(ctx, m.telegram.Bot(), cmd.Chat.ID)
+	writeSpending(html, current, spending, previous, previousSpending.Total())
+	return html.Flush()
+}
+
+func writeSpending(html *html.Writer, period period, spending *Spending, previous period, previousTotal float64) {
+	total := spending.Total()
+	html.Bold("💰 %s", period).
+		Text("\nTotal: %.2f ₽", total)
+
+	if previousTotal > 0 {
+		delta := total/previousTotal - 1
+		icon := "🔺"
+		if delta < 0 {
+			icon = "🔻"
+		}
+
+		html.Text(" (%s %.1f%% vs %.2f ₽ for %s)", icon, math.Abs(delta)*100, previousTotal, previous)
+	}
+
+	html.Text("\nCashback: %.2f ₽", spending.Cashback)
+
+	if len(spending.Categories) > 0 {
+		html.Bold("\n\n▫️ Categories")
+		for _, category := range spending.Categories {
+			html.Text("\n%s – %.2f ₽ (%.1f%%)", category.Category, category.Amount, category.Amount/total*100)
+		}
+	}
+
+	if len(spending.Merchants) > 0 {
+		html.Bold("\n\n▫️ Top merchants")
+		for _, merchant := range spending.Merchants {
+			html.Text("\n%s – %.2f ₽ (%d)", merchant.Name, merchant.Amount, merchant.Count)
+		}
+	}
+}
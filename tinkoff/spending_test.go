@@ -0,0 +1,91 @@
+package tinkoff
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/jfk9w-go/telegram-bot-api/ext/html"
+	"github.com/jfk9w-go/telegram-bot-api/ext/output"
+	"github.com/jfk9w-go/telegram-bot-api/ext/receiver"
+)
+
+func TestParsePeriod(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	for _, unit := range []struct {
+		value                       string
+		since, until, previousSince time.Time
+	}{
+		{
+			value:         "",
+			since:         time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			until:         time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC),
+			previousSince: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			value:         "week",
+			since:         time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC),
+			until:         time.Date(2022, 6, 20, 0, 0, 0, 0, time.UTC),
+			previousSince: time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			value:         "2022-01",
+			since:         time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			until:         time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+			previousSince: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+	} {
+		period, err := parsePeriod(unit.value, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !period.since.Equal(unit.since) || !period.until.Equal(unit.until) {
+			t.Errorf("%s: expected [%s, %s), got [%s, %s)", unit.value, unit.since, unit.until, period.since, period.until)
+		}
+
+		if previous := period.previous(); !previous.since.Equal(unit.previousSince) || !previous.until.Equal(unit.since) {
+			t.Errorf("%s: unexpected previous period [%s, %s)", unit.value, previous.since, previous.until)
+		}
+	}
+
+	if _, err := parsePeriod("yesterday", now); err == nil {
+		t.Errorf("expected error for invalid period")
+	}
+}
+
+func TestWriteSpending_PartialPeriod(t *testing.T) {
+	now := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	current, err := parsePeriod("", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := current.comparable(now)
+	if expected := time.Date(2022, 5, 15, 12, 0, 0, 0, time.UTC); !previous.until.Equal(expected) {
+		t.Fatalf("expected previous period to be clipped at %s, got %s", expected, previous.until)
+	}
+
+	buf := receiver.NewBuffer()
+	writer := (&html.Writer{Out: &output.Paged{Receiver: buf}}).
+		WithContext(output.With(context.Background(), telegram.MaxMessageSize, 0))
+	spending := &Spending{Categories: []SpendingCategory{{Category: "Супермаркеты", Amount: 1100}}}
+	writeSpending(writer, current, spending, previous, 1000)
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buf.Pages) != 1 {
+		t.Fatalf("expected 1 page, got %d", len(buf.Pages))
+	}
+
+	if expected := "Total: 1100.00 ₽ (🔺 10.0% vs 1000.00 ₽ for 01.05.2022 – 15.05.2022)"; !strings.Contains(buf.Pages[0], expected) {
+		t.Errorf("expected %q in %q", expected, buf.Pages[0])
+	}
+
+	if previous := current.comparable(current.until.AddDate(0, 0, 3)); !previous.until.Equal(current.since) {
+		t.Errorf("expected full previous period for a past period, got %s", previous)
+	}
+}
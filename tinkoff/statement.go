@@ -0,0 +1,268 @@
+package tinkoff
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+
+	"github.com/jfk9w-go/flu"
+	"github.com/jfk9w-go/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+var statementHeader = []string{
+	"Time", "Account", "Type", "Status", "Description", "Category", "MCC", "Merchant",
+	"Amount", "Currency", "Account amount", "Account currency", "Cashback",
+	"Receipt address", "Item", "Item price", "Item quantity", "Item sum",
+}
+
+// statementTable converts statement rows to table values.
+// Operations with multiple receipt items span multiple rows, and operation amounts
+// are filled only in the first one so that amount columns can be summed up.
+func statementTable(rows []StatementRow, accounts map[string]tinkoff.Account) [][]any {
+	table := make([][]any, len(rows))
+	for i, row := range rows {
+		var amount, accountAmount, cashbackAmount any = row.Amount, row.AccountAmount, row.CashbackAmount
+		if i > 0 && rows[i-1].OperationID == row.OperationID {
+			amount, accountAmount, cashbackAmount = "", "", ""
+		}
+
+		table[i] = []any{
+			row.Time.In(tinkoff.MoscowLocation).Format("2006-01-02 15:04:05"),
+			accounts[row.AccountID].String(),
+			row.Type, row.Status, row.Description, row.Category, row.MCC, row.Merchant,
+			amount, row.Currency, accountAmount, row.AccountCurrency, cashbackAmount,
+			row.ReceiptAddress, row.ItemName, row.ItemPrice, row.ItemQuantity, row.ItemSum,
+		}
+
+		for j, value := range table[i] {
+			switch value := value.(type) {
+			case *string:
+				if value != nil {
+					table[i][j] = *value
+				} else {
+					table[i][j] = ""
+				}
+			case *float64:
+				if value != nil {
+					table[i][j] = *value
+				} else {
+					table[i][j] = ""
+				}
+			}
+		}
+	}
+
+	return table
+}
+
+func parseStatementDate(value string, location *time.Location) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", value, location)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid date [%s], expected YYYY-MM-DD", value)
+	}
+
+	return date, nil
+}
+
+//goland:noinspection GoSnakeCaseUsage
+func (m *Mixin[C]) Export_statement(ctx context.Context, client telegram.Client, cmd *telegram.Command) error {
+	credential, ok := m.credentials[cmd.User.ID]
+	if !ok {
+		return errors.New("invalid user ID")
+	}
+
+	since, err := parseStatementDate(cmd.Arg(0), tinkoff.MoscowLocation)
+	if err != nil {
+		return err
+	}
+
+	until, err := parseStatementDate(cmd.Arg(1), tinkoff.MoscowLocation)
+	if err != nil {
+		return err
+	}
+
+	until = until.AddDate(0, 0, 1)
+	if !since.Before(until) {
+		return errors.New("start date must not be after end date")
+	}
+
+	accounts, err := m.storage.GetUserAccounts(ctx, credential.Username)
+	if err != nil {
+		return errors.Wrap(err, "get accounts")
+	}
+
+	accountName := cmd.Arg(2)
+	accountsByID := make(map[string]tinkoff.Account, len(accounts))
+	accountIDs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		if accountName == "" || account.String() == accountName {
+			accountsByID[account.ID] = account
+			accountIDs = append(accountIDs, account.ID)
+		}
+	}
+
+	if len(accountIDs) == 0 {
+		return errors.Errorf("no accounts found")
+	}
+
+	rows, err := m.storage.GetStatement(ctx, accountIDs, since, until)
+	if err != nil {
+		return errors.Wrap(err, "get statement")
+	}
+
+	if len(rows) == 0 {
+		return errors.New("no operations found")
+	}
+
+	table := statementTable(rows, accountsByID)
+	filename := fmt.Sprintf("statement_%s_%s", cmd.Arg(0), cmd.Arg(1))
+	for _, format := range []struct {
+		ext    string
+		encode func(io.Writer, []string, [][]any) error
+	}{
+		{"csv", writeCSV},
+		{"xlsx", writeXLSX},
+	} {
+		buffer := new(flu.ByteBuffer)
+		if err := format.encode(buffer.Unmask(), statementHeader, table); err != nil {
+			return errors.Wrapf(err, "encode %s", format.ext)
+		}
+
+		if _, err := client.Send(ctx, cmd.Chat.ID,
+			&telegram.Media{
+				Type:     telegram.Document,
+				Input:    buffer,
+				Filename: filename + "." + format.ext,
+			}, nil,
+		); err != nil {
+			return errors.Wrapf(err, "send %s statement", format.ext)
+		}
+	}
+
+	return nil
+}
+
+func writeCSV(w io.Writer, header []string, table [][]any) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(header))
+	for _, row := range table {
+		for i, value := range row {
+			if number, ok := value.(float64); ok {
+				record[i] = strconv.FormatFloat(number, 'f', -1, 64)
+			} else {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Statement" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+type xlsxInlineString struct {
+	Text string `xml:"t"`
+}
+
+type xlsxCell struct {
+	Type   string            `xml:"t,attr,omitempty"`
+	Value  *string           `xml:"v,omitempty"`
+	Inline *xlsxInlineString `xml:"is,omitempty"`
+}
+
+type xlsxRow struct {
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxWorksheet struct {
+	XMLName xml.Name  `xml:"http://schemas.openxmlformats.org/spreadsheetml/2006/main worksheet"`
+	Rows    []xlsxRow `xml:"sheetData>row"`
+}
+
+func newXLSXCell(value any) xlsxCell {
+	if value, ok := value.(float64); ok {
+		number := strconv.FormatFloat(value, 'f', -1, 64)
+		return xlsxCell{Value: &number}
+	}
+
+	return xlsxCell{Type: "inlineStr", Inline: &xlsxInlineString{Text: fmt.Sprint(value)}}
+}
+
+// writeXLSX writes a minimal single-sheet Office Open XML workbook.
+func writeXLSX(w io.Writer, header []string, table [][]any) error {
+	sheet := xlsxWorksheet{Rows: make([]xlsxRow, 0, len(table)+1)}
+	cells := make([]xlsxCell, len(header))
+	for i, value := range header {
+		cells[i] = newXLSXCell(value)
+	}
+
+	sheet.Rows = append(sheet.Rows, xlsxRow{Cells: cells})
+	for _, row := range table {
+		cells := make([]xlsxCell, len(row))
+		for i, value := range row {
+			cells[i] = newXLSXCell(value)
+		}
+
+		sheet.Rows = append(sheet.Rows, xlsxRow{Cells: cells})
+	}
+
+	archive := zip.NewWriter(w)
+	for _, xlsxPart := range xlsxParts {
+		part, err := archive.Create(xlsxPart.name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(part, xlsxPart.content); err != nil {
+			return err
+		}
+	}
+
+	part, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(part, xml.Header); err != nil {
+		return err
+	}
+
+	if err := xml.NewEncoder(part).Encode(sheet); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
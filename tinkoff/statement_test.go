@@ -0,0 +1,89 @@
+package tinkoff
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+)
+
+func TestStatementTable(t *testing.T) {
+	bread, milk := "Bread", "Milk"
+	at := time.Date(2022, 6, 15, 12, 0, 0, 0, time.UTC)
+	operation := StatementRow{OperationID: 1, Time: at, AccountID: "5001", Amount: 150, AccountAmount: 150, CashbackAmount: 1.5}
+	first, second, other := operation, operation, operation
+	first.ItemName, second.ItemName = &bread, &milk
+	other.OperationID = 2
+
+	accounts := map[string]tinkoff.Account{"5001": {ID: "5001", Name: "Debit"}}
+	table := statementTable([]StatementRow{first, second, other}, accounts)
+	// Amount, Account amount and Cashback columns
+	for _, column := range []int{8, 10, 12} {
+		var sum float64
+		for _, row := range table {
+			if value, ok := row[column].(float64); ok {
+				sum += value
+			}
+		}
+
+		if expected := 2 * table[0][column].(float64); sum != expected {
+			t.Errorf("expected %s column sum %.2f, got %.2f", statementHeader[column], expected, sum)
+		}
+	}
+
+	if table[1][14] != milk || table[1][8] != "" {
+		t.Errorf("unexpected second item row: %+v", table[1])
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeCSV(&buffer, []string{"Name", "Sum"}, [][]any{{"Bread, white", 10.5}, {"Milk", ""}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Name,Sum\n\"Bread, white\",10.5\nMilk,\n"
+	if buffer.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buffer.String())
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := writeXLSX(&buffer, []string{"Name", "Sum"}, [][]any{{"Bread & butter", 10.5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var worksheet xlsxWorksheet
+	if err := xml.Unmarshal(data, &worksheet); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(worksheet.Rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(worksheet.Rows))
+	}
+
+	cells := worksheet.Rows[1].Cells
+	if cells[0].Inline == nil || cells[0].Inline.Text != "Bread & butter" || cells[1].Value == nil || *cells[1].Value != "10.5" {
+		t.Errorf("unexpected cells: %+v", cells)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/jfk9w-go/flu/gormf"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -24,6 +25,12 @@ var (
 
 	//go:embed ddl/trading_positions.sql
 	tradingPositionsDDL string
+
+	//go:embed ddl/purchased_securities_tenant.sql
+	purchasedSecuritiesTenantDDL string
+
+	//go:embed ddl/candles_tenant.sql
+	candlesTenantDDL string
 )
 
 type Storage[C Context] struct {
@@ -42,6 +49,25 @@ func (m *Storage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 
 	db := gorm.DB()
 	db.FullSaveAssociations = true
+	if err := migrate(ctx, db); err != nil {
+		return err
+	}
+
+	m.db = db
+	return nil
+}
+
+// migrate creates or updates tables and views.
+// Tenant DDL is executed before auto migration since it changes primary keys of existing tables.
+func migrate(ctx context.Context, db *gorm.DB) error {
+	if err := db.WithContext(ctx).Exec(purchasedSecuritiesTenantDDL).Error; err != nil {
+		return errors.Wrap(err, "migrate purchased_securities")
+	}
+
+	if err := db.WithContext(ctx).Exec(candlesTenantDDL).Error; err != nil {
+		return errors.Wrap(err, "migrate candles")
+	}
+
 	if err := db.WithContext(ctx).AutoMigrate(
 		tinkoff.Account{},
 		tinkoff.Operation{},
@@ -52,6 +78,10 @@ func (m *Storage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		tinkoff.TradingOperation{},
 		tinkoff.PurchasedSecurity{},
 		tinkoff.Candle{},
+		tinkoff.Session{},
+		BudgetAlert{},
+		OperationAnnouncement{},
+		QueuedShoppingReceipt{},
 	); err != nil {
 		return errors.Wrap(err, "auto migrate")
 	}
@@ -68,7 +98,6 @@ func (m *Storage[C]) Include(ctx context.Context, app apfel.MixinApp[C]) error {
 		return errors.Wrap(err, "create trading_positions view")
 	}
 
-	m.db = db
 	return nil
 }
 
@@ -135,9 +164,32 @@ func (m *Storage[C]) GetOperationRefreshIntervalStart(ctx context.Context, accou
 	return value.Time, nil
 }
 
-func (m *Storage[C]) RefreshOperations(ctx context.Context, accountID string, since time.Time, operations []tinkoff.Operation) error {
-	var model tinkoff.Operation
-	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+func (m *Storage[C]) RefreshOperations(ctx context.Context, accountID string, since time.Time, operations []tinkoff.Operation) ([]uint64, error) {
+	var (
+		model  tinkoff.Operation
+		newIDs []uint64
+	)
+
+	return newIDs, m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existingIDs []uint64
+		if err := tx.Model(&model).
+			Where("account_id = ? and time >= ?", accountID, since).
+			Pluck("id", &existingIDs).
+			Error; err != nil {
+			return errors.Wrap(err, "select existing ids")
+		}
+
+		existing := make(map[uint64]bool, len(existingIDs))
+		for _, id := range existingIDs {
+			existing[id] = true
+		}
+
+		for _, operation := range operations {
+			if !existing[operation.ID] {
+				newIDs = append(newIDs, operation.ID)
+			}
+		}
+
 		deleteTx := tx.
 			Where("debiting_time is null and account_id = ? and time >= ?", accountID, since).
 			Delete(&model)
@@ -185,6 +237,55 @@ func (m *Storage[C]) RemoveShoppingReceiptFlag(ctx context.Context, operationID
 		Error
 }
 
+func (m *Storage[C]) QueueShoppingReceipts(ctx context.Context, username string, operationIDs []uint64, at time.Time) (queued int, err error) {
+	return queued, m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Where("username = ? and not exists (select 1 from operations o where o.id = queued_shopping_receipts.operation_id)", username).
+			Delete(new(QueuedShoppingReceipt)).
+			Error; err != nil {
+			return errors.Wrap(err, "delete removed operations")
+		}
+
+		if len(operationIDs) == 0 {
+			return nil
+		}
+
+		receipts := make([]QueuedShoppingReceipt, len(operationIDs))
+		for i, operationID := range operationIDs {
+			receipts[i] = QueuedShoppingReceipt{
+				OperationID: operationID,
+				Username:    username,
+				NextAttempt: at,
+			}
+		}
+
+		createTx := tx.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			CreateInBatches(receipts, 1000)
+		queued = int(createTx.RowsAffected)
+		return errors.Wrap(createTx.Error, "create")
+	})
+}
+
+func (m *Storage[C]) GetQueuedShoppingReceipts(ctx context.Context, username string, until time.Time) ([]QueuedShoppingReceipt, error) {
+	var receipts []QueuedShoppingReceipt
+	return receipts, m.db.WithContext(ctx).
+		Where("username = ? and next_attempt <= ?", username, until).
+		Order("next_attempt, operation_id").
+		Find(&receipts).
+		Error
+}
+
+func (m *Storage[C]) UpdateQueuedShoppingReceipt(ctx context.Context, receipt QueuedShoppingReceipt) error {
+	return m.db.WithContext(ctx).Save(&receipt).Error
+}
+
+func (m *Storage[C]) RemoveQueuedShoppingReceipt(ctx context.Context, operationID uint64) error {
+	return m.db.WithContext(ctx).
+		Delete(&QueuedShoppingReceipt{OperationID: operationID}).
+		Error
+}
+
 func (m *Storage[C]) GetLatestTime(ctx context.Context, entity interface{}, tenant interface{}) (latestTime time.Time, err error) {
 	timeColumns := gormf.CollectTaggedColumns(entity, "time")
 	var timeColumn string
@@ -214,19 +315,296 @@ func (m *Storage[C]) GetLatestTime(ctx context.Context, entity interface{}, tena
 	return
 }
 
-func (m *Storage[C]) DB(ctx context.Context) *gorm.DB {
-	return m.db.WithContext(ctx)
+func (m *Storage[C]) StoreTradingOperations(ctx context.Context, username string, items []tinkoff.TradingOperation) error {
+	for i := range items {
+		items[i].Username = username
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		batch := gormf.Batch[tinkoff.TradingOperation](items)
+		return batch.Ensure(tx, "primaryKey")
+	})
 }
 
-func (m *Storage[C]) Tx(ctx context.Context, tx func(tx *gorm.DB) error) error {
-	return m.db.WithContext(ctx).Transaction(tx)
+func (m *Storage[C]) StorePurchasedSecurities(ctx context.Context, username string, items []tinkoff.PurchasedSecurity) error {
+	for i := range items {
+		items[i].Username = username
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		batch := gormf.Batch[tinkoff.PurchasedSecurity](items)
+		return batch.Ensure(tx, "primaryKey")
+	})
 }
 
-func (m *Storage[C]) GetTradingPositions(ctx context.Context, from time.Time, username string) ([]TradingPosition, error) {
+func (m *Storage[C]) StoreCandles(ctx context.Context, username string, candles []tinkoff.Candle) error {
+	for i := range candles {
+		candles[i].Username = username
+	}
+
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		batch := gormf.Batch[tinkoff.Candle](candles)
+		return batch.Ensure(tx, "primaryKey")
+	})
+}
+
+func (m *Storage[C]) GetTradingPositions(ctx context.Context, username string) ([]TradingPosition, error) {
 	ps := make([]TradingPosition, 0)
 	return ps, m.db.WithContext(ctx).
 		Table("trading_positions").
-		Where("(sell_time is null or sell_time >= ?) and username = ?", from, username).
+		Where("username = ?", username).
 		Scan(&ps).
 		Error
 }
+
+func (m *Storage[C]) GetTradingCurrencies(ctx context.Context, username string) ([]string, error) {
+	currencies := make([]string, 0)
+	return currencies, m.db.WithContext(ctx).
+		Model(new(tinkoff.TradingOperation)).
+		Where("username = ?", username).
+		Distinct("currency").
+		Scan(&currencies).
+		Error
+}
+
+func (m *Storage[C]) GetLatestCandleDates(ctx context.Context, username string) (map[string]time.Time, error) {
+	var (
+		model tinkoff.Candle
+		rows  []struct {
+			Ticker string
+			Date   time.Time
+		}
+	)
+
+	tx, err := gormf.Filter(m.db.WithContext(ctx), &model, "tenant", "=", username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.
+		Model(&model).
+		Select("ticker, max(date) as date").
+		Group("ticker").
+		Scan(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		dates[row.Ticker] = row.Date
+	}
+
+	return dates, nil
+}
+
+func (m *Storage[C]) LoadSessionID(ctx context.Context, username string) (string, error) {
+	var session tinkoff.Session
+	return session.ID, m.db.WithContext(ctx).
+		Where("username = ?", username).
+		Limit(1).
+		Find(&session).
+		Error
+}
+
+func (m *Storage[C]) StoreSessionID(ctx context.Context, username, sessionID string) error {
+	session := &tinkoff.Session{
+		Username: username,
+		ID:       sessionID,
+	}
+
+	onConflict := gormf.OnConflictClause(session, "primaryKey", true, nil)
+	return m.db.WithContext(ctx).
+		Clauses(onConflict).
+		Create(session).
+		Error
+}
+
+func (m *Storage[C]) RemoveSessionID(ctx context.Context, username string) error {
+	return m.db.WithContext(ctx).
+		Where("username = ?", username).
+		Delete(new(tinkoff.Session)).
+		Error
+}
+
+const topMerchantsLimit = 5
+
+func (m *Storage[C]) GetSpending(ctx context.Context, username string, since, until time.Time) (*Spending, error) {
+	var spending Spending
+	return &spending, m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		debit := tx.Table("debit").
+			Where(`account_id in (select id from accounts where username = ?) and "time" >= ? and "time" < ?`,
+				username, since, until).
+			Session(&gorm.Session{})
+
+		if err := debit.
+			Select("coalesce(category, '') as category, sum(account_amount) as amount").
+			Group("category").
+			Order("amount desc").
+			Scan(&spending.Categories).
+			Error; err != nil {
+			return errors.Wrap(err, "select categories")
+		}
+
+		if err := debit.
+			Select("coalesce(merchant_name, description) as name, sum(account_amount) as amount, count(1) as count").
+			Group("coalesce(merchant_name, description)").
+			Order("amount desc").
+			Limit(topMerchantsLimit).
+			Scan(&spending.Merchants).
+			Error; err != nil {
+			return errors.Wrap(err, "select top merchants")
+		}
+
+		if err := debit.
+			Select("coalesce(sum(cashback_amount), 0)").
+			Scan(&spending.Cashback).
+			Error; err != nil {
+			return errors.Wrap(err, "select cashback")
+		}
+
+		return nil
+	})
+}
+
+func (m *Storage[C]) GetBudgetSpending(ctx context.Context, username string, budget Budget, since, until time.Time) (float64, error) {
+	query := m.db.WithContext(ctx).Table("debit").
+		Where(`account_id in (select id from accounts where username = ?) and "time" >= ? and "time" < ?`,
+			username, since, until)
+
+	if budget.Category != "" {
+		query = query.Where("category = ?", budget.Category)
+	}
+
+	if budget.MCC != "" {
+		query = query.Where("mcc = ?", budget.MCC)
+	}
+
+	var amount float64
+	return amount, query.
+		Select("coalesce(sum(account_amount), 0)").
+		Scan(&amount).
+		Error
+}
+
+func (m *Storage[C]) HasBudgetAlert(ctx context.Context, alert BudgetAlert) (bool, error) {
+	var count int64
+	return count > 0, m.db.WithContext(ctx).
+		Model(new(BudgetAlert)).
+		Where("username = ? and budget = ? and period = ? and threshold = ?",
+			alert.Username, alert.Budget, alert.Period, alert.Threshold).
+		Count(&count).
+		Error
+}
+
+func (m *Storage[C]) AddBudgetAlert(ctx context.Context, alert BudgetAlert) (bool, error) {
+	tx := m.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&alert)
+	return tx.RowsAffected > 0, tx.Error
+}
+
+func (m *Storage[C]) IsFirstMerchantOperation(ctx context.Context, username string, operation tinkoff.Operation) (bool, error) {
+	var count int64
+	return count == 0, m.db.WithContext(ctx).
+		Model(new(tinkoff.Operation)).
+		Where("account_id in (select id from accounts where username = ?)", username).
+		Where(`merchant_name = ? and id != ? and "time" < ?`,
+			operation.Merchant.Name, operation.ID, time.Time(operation.Time)).
+		Count(&count).
+		Error
+}
+
+func (m *Storage[C]) AddOperationAnnouncement(ctx context.Context, announcement OperationAnnouncement) (bool, error) {
+	announcement.Pending = true
+	tx := m.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&announcement)
+	return tx.RowsAffected > 0, tx.Error
+}
+
+func (m *Storage[C]) GetPendingOperationAnnouncements(ctx context.Context, accountID string) ([]OperationAnnouncement, error) {
+	announcements := make([]OperationAnnouncement, 0)
+	return announcements, m.db.WithContext(ctx).
+		Where("pending and account_id = ?", accountID).
+		Order("created_at, operation_id").
+		Find(&announcements).
+		Error
+}
+
+func (m *Storage[C]) CompleteOperationAnnouncement(ctx context.Context, operationID uint64) error {
+	return m.db.WithContext(ctx).
+		Model(&OperationAnnouncement{OperationID: operationID}).
+		Update("pending", false).
+		Error
+}
+
+func (m *Storage[C]) GetUserAccounts(ctx context.Context, username string) ([]tinkoff.Account, error) {
+	var accounts []tinkoff.Account
+	return accounts, m.db.WithContext(ctx).
+		Where("username = ?", username).
+		Order("name, id").
+		Find(&accounts).
+		Error
+}
+
+func (m *Storage[C]) GetStatement(ctx context.Context, accountIDs []string, since, until time.Time) ([]StatementRow, error) {
+	var rows []StatementRow
+	return rows, m.db.WithContext(ctx).
+		Table("operations o").
+		Select(`o.id as operation_id, o."time", o.account_id, o.type, o.status, o.description,
+			coalesce(o.category, '') as category, o.mcc, coalesce(o.merchant_name, '') as merchant,
+			o.amount, o.currency, o.account_amount, o.account_currency, o.cashback_amount,
+			r.retail_place_address as receipt_address,
+			i.name as item_name, i.price as item_price, i.quantity as item_quantity, i.sum as item_sum`).
+		Joins("left join shopping_receipts r on r.operation_id = o.id").
+		Joins("left join shopping_receipt_items i on i.shopping_receipt_id = r.operation_id").
+		Where(`o.account_id in ? and o."time" >= ? and o."time" < ?`, accountIDs, since, until).
+		Order(`o."time", o.id, i.name`).
+		Scan(&rows).
+		Error
+}
+
+const topGoodsLimit = 10
+
+// receiptItems selects identified shopping receipt items (i) of the user joined with their operations (o).
+func (m *Storage[C]) receiptItems(ctx context.Context, username string) *gorm.DB {
+	return m.db.WithContext(ctx).
+		Table("shopping_receipt_items i").
+		Joins("inner join operations o on o.id = i.shopping_receipt_id").
+		Where("i.good_id <> 0 and o.account_id in (select id from accounts where username = ?)", username)
+}
+
+func (m *Storage[C]) GetTopGoods(ctx context.Context, username string, since, until time.Time) ([]Good, error) {
+	var goods []Good
+	return goods, m.receiptItems(ctx, username).
+		Select("i.good_id, max(i.name) as name, count(1) as count, sum(i.quantity) as quantity, sum(i.sum) as sum").
+		Where(`o."time" >= ? and o."time" < ?`, since, until).
+		Group("i.good_id").
+		Order("count desc, sum desc").
+		Limit(topGoodsLimit).
+		Scan(&goods).
+		Error
+}
+
+func (m *Storage[C]) GetGoodPrices(ctx context.Context, username string, goodID uint) ([]GoodPrice, error) {
+	var prices []GoodPrice
+	return prices, m.receiptItems(ctx, username).
+		Select(`date_trunc('month', o."time" at time zone 'Europe/Moscow') as month, max(i.name) as name,
+			min(i.price) as min_price, avg(i.price) as avg_price, max(i.price) as max_price`).
+		Where("i.good_id = ?", goodID).
+		Group("1").
+		Order("1").
+		Scan(&prices).
+		Error
+}
+
+func (m *Storage[C]) GetGoodPurchases(ctx context.Context, username string, since time.Time) ([]GoodPurchase, error) {
+	var purchases []GoodPurchase
+	return purchases, m.receiptItems(ctx, username).
+		Select(`i.good_id, i.name, i.price, o."time"`).
+		Where(`o."time" >= ?`, since).
+		Order(`i.good_id, o."time"`).
+		Scan(&purchases).
+		Error
+}
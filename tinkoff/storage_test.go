@@ -0,0 +1,188 @@
+package tinkoff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"homebot/3rdparty/tinkoff"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testPostgresDSNEnv contains PostgreSQL connection string for storage tests.
+// The tests are skipped if it is not set.
+const testPostgresDSNEnv = "HOMEBOT_TEST_POSTGRES_DSN"
+
+// openTestSchema opens PostgreSQL database with search_path set to a new empty schema.
+// The schema is dropped on cleanup.
+func openTestSchema(t *testing.T) *gorm.DB {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSNEnv)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), new(gorm.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fmt.Sprintf("homebot_test_%d", time.Now().UnixNano())
+	if err := db.Exec("create schema " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Exec("drop schema " + schema + " cascade") })
+
+	switch {
+	case strings.Contains(dsn, "://") && strings.Contains(dsn, "?"):
+		dsn += "&search_path=" + schema
+	case strings.Contains(dsn, "://"):
+		dsn += "?search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+
+	db, err = gorm.Open(postgres.Open(dsn), new(gorm.Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMigrate_EmptySchema(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSchema(t)
+
+	// the second run checks that migrations are idempotent
+	for i := 0; i < 2; i++ {
+		if err := migrate(ctx, db); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	for _, table := range []string{"purchased_securities", "candles", "queued_shopping_receipts"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("expected %s to be created", table)
+		}
+	}
+}
+
+func TestMigrate_PurchasedSecuritiesTenant(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSchema(t)
+
+	if err := db.AutoMigrate(new(tinkoff.TradingOperation)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range []string{
+		`create table purchased_securities (
+			ticker        text,
+			security_type text,
+			currency      char(3) not null,
+			value         decimal not null,
+			"time"        date,
+			primary key (ticker, security_type, "time"))`,
+		`insert into purchased_securities (ticker, security_type, currency, value, "time")
+		values ('SBER', 'Stock', 'RUB', 120, '2022-06-01'),
+		       ('YNDX', 'Stock', 'RUB', 1800, '2022-06-01'),
+		       ('AAPL', 'Stock', 'USD', 150, '2022-06-01')`,
+		`insert into trading_operations (username, id, "time", type, ticker, payment, currency, description)
+		values ('alice', 1, '2022-05-01', 'Buy', 'SBER', -120, 'RUB', ''),
+		       ('bob', 2, '2022-05-02', 'Buy', 'SBER', -120, 'RUB', ''),
+		       ('bob', 3, '2022-05-03', 'Buy', 'YNDX', -1800, 'RUB', ''),
+		       ('bob', 4, '2022-05-04', 'Sell', 'YNDX', 1800, 'RUB', '')`,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	if err := db.Table("purchased_securities").
+		Order("username, ticker").
+		Pluck("username || '/' || broker_account_type || '/' || portfolio_currency || '/' || ticker", &keys).
+		Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"alice/Tinkoff/RUB/SBER", "bob/Tinkoff/RUB/SBER", "bob/Tinkoff/RUB/YNDX"}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestQueuedShoppingReceipt_RefreshOperations(t *testing.T) {
+	ctx := context.Background()
+	db := openTestSchema(t)
+	if err := migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &Storage[Context]{db: db}
+	if err := storage.RefreshAccounts(ctx, "test", []tinkoff.Account{{ID: "5001", Name: "Debit", Type: "Current", Username: "test"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	operations := []tinkoff.Operation{{
+		ID:                 1,
+		Time:               tinkoff.OperationTime(since.Add(time.Hour)),
+		Type:               "Debit",
+		Group:              "PAY",
+		Status:             "OK",
+		Description:        "Shop",
+		MCC:                "5411",
+		AccountID:          "5001",
+		HasShoppingReceipt: true,
+	}}
+
+	if _, err := storage.RefreshOperations(ctx, "5001", since, operations); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.QueueShoppingReceipts(ctx, "test", []uint64{1}, since); err != nil {
+		t.Fatal(err)
+	}
+
+	postponed := QueuedShoppingReceipt{OperationID: 1, Username: "test", Attempts: 3, NextAttempt: since, LastError: "rate limit"}
+	if err := storage.UpdateQueuedShoppingReceipt(ctx, postponed); err != nil {
+		t.Fatal(err)
+	}
+
+	// pending operations are deleted and inserted again on refresh
+	if _, err := storage.RefreshOperations(ctx, "5001", since, operations); err != nil {
+		t.Fatal(err)
+	}
+
+	receipts, err := storage.GetQueuedShoppingReceipts(ctx, "test", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(receipts) != 1 || receipts[0].Attempts != 3 {
+		t.Fatalf("expected queued receipt attempts to survive refresh, got %+v", receipts)
+	}
+
+	if _, err := storage.RefreshOperations(ctx, "5001", since, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.QueueShoppingReceipts(ctx, "test", nil, since); err != nil {
+		t.Fatal(err)
+	}
+
+	if receipts, err := storage.GetQueuedShoppingReceipts(ctx, "test", since); err != nil || len(receipts) != 0 {
+		t.Errorf("expected receipt of removed operation to be dropped, got %+v (%v)", receipts, err)
+	}
+}
@@ -5,8 +5,6 @@ import (
 	"time"
 
 	"homebot/3rdparty/tinkoff"
-
-	"gorm.io/gorm"
 )
 
 type (
@@ -20,6 +18,7 @@ type Client interface {
 	GetOperations(ctx context.Context, req tinkoff.Operations) ([]tinkoff.Operation, error)
 	GetShoppingReceipt(ctx context.Context, req tinkoff.OperationReceipt) (*tinkoff.ShoppingReceipt, error)
 	GetTradingOperations(ctx context.Context, req tinkoff.TradingOperations) ([]tinkoff.TradingOperation, error)
+	GetBrokerAccounts(ctx context.Context, req tinkoff.BrokerAccounts) ([]tinkoff.BrokerAccount, error)
 	GetPurchasedSecurities(ctx context.Context, req tinkoff.PurchasedSecurities) ([]tinkoff.PurchasedSecurity, error)
 	GetCandles(ctx context.Context, req tinkoff.Candles) ([]tinkoff.Candle, error)
 }
@@ -30,15 +29,143 @@ type TradingPosition struct {
 	SellTime *time.Time
 }
 
+type SpendingCategory struct {
+	Category string
+	Amount   float64
+}
+
+type SpendingMerchant struct {
+	Name   string
+	Amount float64
+	Count  int
+}
+
+type Spending struct {
+	Categories []SpendingCategory
+	Merchants  []SpendingMerchant
+	Cashback   float64
+}
+
+func (s *Spending) Total() (total float64) {
+	for _, category := range s.Categories {
+		total += category.Amount
+	}
+
+	return
+}
+
+// Good is a shopping receipt good bought within a period.
+type Good struct {
+	GoodID   uint
+	Name     string
+	Count    int
+	Quantity float64
+	Sum      float64
+}
+
+// GoodPrice is a good price range within a month.
+type GoodPrice struct {
+	Month    time.Time
+	Name     string
+	MinPrice float64
+	AvgPrice float64
+	MaxPrice float64
+}
+
+// GoodPurchase is a single purchase of a good.
+type GoodPurchase struct {
+	GoodID uint
+	Name   string
+	Price  float64
+	Time   time.Time
+}
+
+// PriceJump is the latest purchase of a regularly bought good which is more expensive than its average price.
+type PriceJump struct {
+	GoodPurchase
+	AvgPrice float64
+	Count    int
+}
+
+func (j PriceJump) Increase() float64 {
+	return j.Price/j.AvgPrice - 1
+}
+
+// StatementRow is a single operation (or a receipt item of the operation) in a bank statement.
+type StatementRow struct {
+	OperationID     uint64
+	Time            time.Time
+	AccountID       string
+	Type            string
+	Status          string
+	Description     string
+	Category        string
+	MCC             string
+	Merchant        string
+	Amount          float64
+	Currency        string
+	AccountAmount   float64
+	AccountCurrency string
+	CashbackAmount  float64
+	ReceiptAddress  *string
+	ItemName        *string
+	ItemPrice       *float64
+	ItemQuantity    *float64
+	ItemSum         *float64
+}
+
+// BudgetAlert marks a budget threshold which has already been announced for the period.
+type BudgetAlert struct {
+	Username  string    `gorm:"primaryKey"`
+	Budget    string    `gorm:"primaryKey"`
+	Period    time.Time `gorm:"primaryKey;type:date"`
+	Threshold int       `gorm:"primaryKey;autoIncrement:false"`
+}
+
+// OperationAnnouncement is an announcement of an operation matching watch rules.
+// It is pending until the announcement is sent.
+type OperationAnnouncement struct {
+	OperationID uint64    `gorm:"primaryKey;autoIncrement:false"`
+	AccountID   string    `gorm:"not null;default:'';index"`
+	Text        string    `gorm:"not null;default:''"`
+	CreatedAt   time.Time `gorm:"not null"`
+	Pending     bool      `gorm:"not null;default:false"`
+}
+
+// QueuedShoppingReceipt is a pending shopping receipt fetch.
+// It is not bound to the operation row, so that attempts survive operation refreshes.
+// Entries of removed operations are dropped when receipts are queued.
+type QueuedShoppingReceipt struct {
+	OperationID uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Username    string    `gorm:"not null;index"`
+	Attempts    int       `gorm:"not null"`
+	NextAttempt time.Time `gorm:"not null;index"`
+	LastError   string    `gorm:"not null"`
+}
+
 type StorageInterface interface {
 	RefreshAccounts(ctx context.Context, username string, accounts []tinkoff.Account) error
 	GetOperationRefreshIntervalStart(ctx context.Context, accountID string) (time.Time, error)
-	RefreshOperations(ctx context.Context, accountID string, since time.Time, operations []tinkoff.Operation) error
+	RefreshOperations(ctx context.Context, accountID string, since time.Time, operations []tinkoff.Operation) (newIDs []uint64, err error)
 	GetPendingShoppingReceiptOperationIDs(ctx context.Context, accountID string) ([]uint64, error)
 	StoreShoppingReceipt(ctx context.Context, receipt *tinkoff.ShoppingReceipt) error
 	RemoveShoppingReceiptFlag(ctx context.Context, operationID uint64) error
+	QueueShoppingReceipts(ctx context.Context, username string, operationIDs []uint64, at time.Time) (int, error)
+	GetQueuedShoppingReceipts(ctx context.Context, username string, until time.Time) ([]QueuedShoppingReceipt, error)
+	UpdateQueuedShoppingReceipt(ctx context.Context, receipt QueuedShoppingReceipt) error
+	RemoveQueuedShoppingReceipt(ctx context.Context, operationID uint64) error
 	GetLatestTime(ctx context.Context, entity interface{}, tenant interface{}) (latestTime time.Time, err error)
-	GetTradingPositions(ctx context.Context, from time.Time, username string) ([]TradingPosition, error)
-	DB(ctx context.Context) *gorm.DB
-	Tx(ctx context.Context, tx func(tx *gorm.DB) error) error
+	GetTradingPositions(ctx context.Context, username string) ([]TradingPosition, error)
+	GetTradingCurrencies(ctx context.Context, username string) ([]string, error)
+	GetLatestCandleDates(ctx context.Context, username string) (map[string]time.Time, error)
+	StoreTradingOperations(ctx context.Context, username string, items []tinkoff.TradingOperation) error
+	StorePurchasedSecurities(ctx context.Context, username string, items []tinkoff.PurchasedSecurity) error
+	StoreCandles(ctx context.Context, username string, candles []tinkoff.Candle) error
+	GetBudgetSpending(ctx context.Context, username string, budget Budget, since, until time.Time) (float64, error)
+	HasBudgetAlert(ctx context.Context, alert BudgetAlert) (bool, error)
+	AddBudgetAlert(ctx context.Context, alert BudgetAlert) (bool, error)
+	IsFirstMerchantOperation(ctx context.Context, username string, operation tinkoff.Operation) (bool, error)
+	AddOperationAnnouncement(ctx context.Context, announcement OperationAnnouncement) (bool, error)
+	GetPendingOperationAnnouncements(ctx context.Context, accountID string) ([]OperationAnnouncement, error)
+	CompleteOperationAnnouncement(ctx context.Context, operationID uint64) error
 }